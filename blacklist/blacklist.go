@@ -22,11 +22,15 @@ func (b *Blacklist) IsBlacklisted(line []byte) bool {
 
 //PrepareBlacklist Parse the string using ; separator and return the Blacklist struct
 func PrepareBlacklist(blacklist *string) Blacklist {
+	return FromList(strings.Split(*blacklist, ";"))
+}
+
+//FromList Compile every non empty regex of the list and return the Blacklist struct
+func FromList(list []string) Blacklist {
 
 	b := Blacklist{}
 
-	split := strings.Split(*blacklist, ";")
-	for _, r := range split {
+	for _, r := range list {
 		if len(r) > 0 {
 			rexp := regexp.MustCompile(r)
 			b.regexp = append(b.regexp, rexp)
@@ -67,3 +67,16 @@ func TestPrepareBlacklist(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestFromList(t *testing.T) {
+
+	b := FromList([]string{"foo;bar", ""})
+
+	if b.IsBlacklisted([]byte("foo;bar")) != true {
+		t.Error()
+	}
+
+	if b.IsBlacklisted([]byte("foo")) != false {
+		t.Error()
+	}
+}
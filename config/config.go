@@ -0,0 +1,1095 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cdemers/journald2graylog/gelf"
+	"github.com/cdemers/journald2graylog/journald"
+	"github.com/cdemers/journald2graylog/logging"
+)
+
+// Config is the structure that holds the complete journald2graylog
+// configuration, as it can be expressed in the YAML configuration file.
+type Config struct {
+	Verbose          bool     `yaml:"verbose"`
+	EnableRawLogLine bool     `yaml:"enable_rawlogline"`
+	Blacklist        []string `yaml:"blacklist"`
+	QueueSize        int      `yaml:"queue_size"`
+	HTTPListen       string   `yaml:"http_listen"`
+
+	Graylog    Graylog    `yaml:"graylog"`
+	Outputs    Outputs    `yaml:"outputs"`
+	Health     Health     `yaml:"health"`
+	Log        Log        `yaml:"log"`
+	Input      Input      `yaml:"input"`
+	Multiline  Multiline  `yaml:"multiline"`
+	Docker     Docker     `yaml:"docker"`
+	Kubernetes Kubernetes `yaml:"kubernetes"`
+	JSON       JSON       `yaml:"json"`
+	Logfmt     Logfmt     `yaml:"logfmt"`
+	Grok       Grok       `yaml:"grok"`
+	Redact     Redact     `yaml:"redact"`
+	Timestamp  Timestamp  `yaml:"timestamp"`
+	GELF       GELF       `yaml:"gelf"`
+	Host       Host       `yaml:"host"`
+}
+
+// Host identity strategies, they define the host the entries are sent for.
+const (
+	// HostJournal uses the _HOSTNAME of the entries, or the hostname of the
+	// local host if they have none.
+	HostJournal = "journal"
+	// HostOS uses the hostname of the local host.
+	HostOS = "os"
+	// HostFQDN uses the name the addresses of the local host resolve back
+	// to.
+	HostFQDN = "fqdn"
+	// HostMachineID uses the _MACHINE_ID of the entries, or the machine ID
+	// of the local host if they have none.
+	HostMachineID = "machine-id"
+	// HostFile uses the first line of a file, such as the /etc/hostname of
+	// the node mounted in a container.
+	HostFile = "file"
+)
+
+// Host holds the parameters of the host identity of the entries.
+type Host struct {
+	// Override, if set, is the host of all the entries, whatever the
+	// strategy.
+	Override string `yaml:"override"`
+	Strategy string `yaml:"strategy"`
+	// File is the file read by the file strategy.
+	File string `yaml:"file"`
+	// OriginalField, if set, is the field the _HOSTNAME of the entries is
+	// kept in when it is not their host.
+	OriginalField string `yaml:"original_field"`
+}
+
+// GELF holds the parameters of the building of the GELF messages.
+type GELF struct {
+	// FacilityFormat is the Go template of the facility, see the
+	// facilityData type of the main package for its fields.
+	FacilityFormat string `yaml:"facility_format"`
+	// FieldTypes are the types the additional fields are converted to, by
+	// name: int, float, string or bool. The standard fields keep theirs.
+	FieldTypes map[string]string `yaml:"field_types"`
+	// EmptyMessages is what is done with the entries whose message is
+	// empty, as GELF requires a short message.
+	EmptyMessages string `yaml:"empty_messages"`
+	// Placeholder is the short message of the entries whose message is
+	// empty, when they are not dropped.
+	Placeholder string `yaml:"placeholder"`
+}
+
+// What to do with the entries whose message is empty.
+const (
+	EmptyPlaceholder = "placeholder"
+	EmptyDrop        = "drop"
+)
+
+// Timestamp holds the parameters of the dating of the entries.
+type Timestamp struct {
+	// Sources are the timestamps the entries are dated with, in order of
+	// preference, see the journald.Timestamp constants.
+	Sources []string `yaml:"sources"`
+	// MaxFuture is how far in the future a timestamp can be before it is
+	// considered invalid.
+	MaxFuture time.Duration `yaml:"max_future"`
+}
+
+// Redaction modes, they define what the sensitive data is replaced with.
+const (
+	// RedactReplace replaces the sensitive data with a fixed text.
+	RedactReplace = "replace"
+	// RedactHash replaces the sensitive data with its HMAC, computed with a
+	// local key, so that the same values can still be correlated.
+	RedactHash = "hash"
+)
+
+// Redact holds the parameters of the redaction of the sensitive data, such
+// as credit card numbers or passwords, found in the entries.
+type Redact struct {
+	Enabled bool `yaml:"enabled"`
+	// Detectors are the names of the built-in detectors used.
+	Detectors []string `yaml:"detectors"`
+	// Patterns are additional regexes matching sensitive data.
+	Patterns    []RedactPattern `yaml:"patterns"`
+	Mode        string          `yaml:"mode"`
+	Replacement string          `yaml:"replacement"`
+	// KeyFile is the path of the file holding the HMAC key of the hash mode.
+	KeyFile string `yaml:"key_file"`
+}
+
+// RedactPattern is a regex matching sensitive data, only its group named
+// "secret" is redacted if it has one.
+type RedactPattern struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// Grok holds the rules extracting fields from the messages with named
+// captures, in the way of the Logstash grok filter.
+type Grok struct {
+	// Patterns are named patterns added to the built-in ones, or replacing
+	// them, that the rules can reference.
+	Patterns map[string]string `yaml:"patterns"`
+	Rules    []GrokRule        `yaml:"rules"`
+}
+
+// GrokRule extracts fields from the messages of some units or identifiers.
+type GrokRule struct {
+	Name string `yaml:"name"`
+	// Units and Identifiers select the entries the rule applies to, by
+	// systemd unit or syslog identifier, it applies to all of them if none
+	// are given.
+	Units       []string `yaml:"units"`
+	Identifiers []string `yaml:"identifiers"`
+	Pattern     string   `yaml:"pattern"`
+}
+
+// Logfmt holds the parameters of the parsing of the key=value pairs of the
+// messages into GELF additional fields.
+type Logfmt struct {
+	Enabled bool `yaml:"enabled"`
+	// Units and Identifiers select the entries whose messages are parsed,
+	// by systemd unit or syslog identifier, all of them are parsed if none
+	// are given.
+	Units       []string `yaml:"units"`
+	Identifiers []string `yaml:"identifiers"`
+	// Prefix is prepended to the names of the fields.
+	Prefix string `yaml:"prefix"`
+	// Strip enables removing the pairs from the message, when no message is
+	// lifted.
+	Strip bool `yaml:"strip"`
+	// MessageKeys, LevelKeys and TimestampKeys are the keys the message, the
+	// level and the timestamp of the entry are lifted from, the first one
+	// present is used.
+	MessageKeys   []string `yaml:"message_keys"`
+	LevelKeys     []string `yaml:"level_keys"`
+	TimestampKeys []string `yaml:"timestamp_keys"`
+}
+
+// JSON holds the parameters of the parsing of the messages that are JSON
+// objects into GELF additional fields.
+type JSON struct {
+	Enabled bool `yaml:"enabled"`
+	// Prefix is prepended to the names of the fields.
+	Prefix string `yaml:"prefix"`
+	// MaxDepth is the depth of the nested objects that are flattened into
+	// fields, those nested deeper are sent as JSON text.
+	MaxDepth int `yaml:"max_depth"`
+	// MessageKeys, LevelKeys and TimestampKeys are the keys the message, the
+	// level and the timestamp of the entry are lifted from, the first one
+	// present is used.
+	MessageKeys   []string `yaml:"message_keys"`
+	LevelKeys     []string `yaml:"level_keys"`
+	TimestampKeys []string `yaml:"timestamp_keys"`
+}
+
+// Kubernetes holds the parameters of the handling of the entries logged by
+// the containers of Kubernetes pods.
+type Kubernetes struct {
+	// Metadata enables adding the namespace, the pod and the container that
+	// logged an entry, as found in its container name or cgroup path.
+	Metadata bool `yaml:"metadata"`
+	// Enrich enables adding the labels and the annotations of the pods,
+	// watched through the Kubernetes API.
+	Enrich bool `yaml:"enrich"`
+	// Node is the name of the node whose pods are watched.
+	Node string `yaml:"node"`
+	// Labels are the names of the labels to add, all of them are added if
+	// none are given.
+	Labels []string `yaml:"labels"`
+	// Annotations are the names of the annotations to add.
+	Annotations []string `yaml:"annotations"`
+}
+
+// Docker holds the parameters of the handling of the entries logged by
+// Docker's journald logging driver.
+type Docker struct {
+	// JoinPartial enables the joining of the fragments of the lines Docker
+	// splits into several entries flagged CONTAINER_PARTIAL_MESSAGE.
+	JoinPartial bool `yaml:"join_partial"`
+	// PartialMaxSize is the maximum size, in bytes, of a joined message, the
+	// fragments beyond it are dropped.
+	PartialMaxSize int `yaml:"partial_max_size"`
+	// PartialTimeout is how long a line waits for its next fragment before
+	// it is sent incomplete.
+	PartialTimeout time.Duration `yaml:"partial_timeout"`
+
+	// Enrich enables adding the image and the labels of the containers,
+	// queried from the Docker daemon, to their entries.
+	Enrich bool   `yaml:"enrich"`
+	Socket string `yaml:"socket"`
+	// Labels are the names of the labels to add, all of them are added if
+	// none are given.
+	Labels []string `yaml:"labels"`
+	// CacheSize is the number of containers whose metadata is cached.
+	CacheSize int `yaml:"cache_size"`
+}
+
+// Multiline holds the parameters of the joining of the entries that continue
+// a previous message, such as the lines of a stack trace.
+type Multiline struct {
+	Enabled bool `yaml:"enabled"`
+	// Start matches the first line of a message, the lines that do not match
+	// it continue the previous message.
+	Start string `yaml:"start"`
+	// Continuation matches the lines that continue the previous message.
+	Continuation string `yaml:"continuation"`
+	// FlushTimeout is how long a message waits for its next line before it
+	// is sent.
+	FlushTimeout time.Duration `yaml:"flush_timeout"`
+	// MaxLines is the maximum number of lines of a message.
+	MaxLines int `yaml:"max_lines"`
+}
+
+// What to do with the log lines bigger than the maximum entry size.
+const (
+	OversizeDrop     = "drop"
+	OversizeTruncate = "truncate"
+)
+
+// Input holds the parameters of the reading of the journald JSON log lines.
+type Input struct {
+	// MaxEntrySize is the maximum size, in bytes, of a log line.
+	MaxEntrySize int `yaml:"max_entry_size"`
+	// Oversize is what to do with the log lines bigger than MaxEntrySize,
+	// either drop them or truncate their message.
+	Oversize string `yaml:"oversize"`
+}
+
+// Log holds the parameters of the internal diagnostics.
+type Log struct {
+	Level         string `yaml:"level"`
+	Format        string `yaml:"format"`
+	DebugPayloads bool   `yaml:"debug_payloads"`
+	// Graylog enables shipping the diagnostics to the destinations, with
+	// their own facility.
+	Graylog  bool   `yaml:"graylog"`
+	Facility string `yaml:"facility"`
+}
+
+// Health holds the thresholds of the /healthz and /readyz probes.
+type Health struct {
+	// InputTimeout is how long the input can stay silent before the process
+	// is considered not alive, zero disables the check.
+	InputTimeout time.Duration `yaml:"input_timeout"`
+	// StallTimeout is how long sending can stay without progress, while
+	// messages are waiting, before the process is considered not alive.
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+	// ReadyQueueRatio is the ratio of the queue size above which the process
+	// is not ready.
+	ReadyQueueRatio float64 `yaml:"ready_queue_ratio"`
+}
+
+// Graylog holds the parameters of the Graylog server the log entries are
+// forwarded to.
+type Graylog struct {
+	Hostname   string `yaml:"hostname"`
+	Port       int    `yaml:"port"`
+	PacketSize int    `yaml:"packet_size"`
+}
+
+// Output modes, they define how the log entries are dispatched between the
+// destinations.
+const (
+	// ModeFanout sends every entry to all the destinations.
+	ModeFanout = "fanout"
+	// ModeFailover sends every entry to the first healthy destination.
+	ModeFailover = "failover"
+	// ModeLoadBalance sends every entry to the next healthy destination, in a
+	// round-robin fashion.
+	ModeLoadBalance = "loadbalance"
+)
+
+// Destination types.
+const (
+	TypeGELFUDP   = "gelf-udp"
+	TypeGELFTCP   = "gelf-tcp"
+	TypeGELFAMQP  = "gelf-amqp"
+	TypeSyslogUDP = "syslog-udp"
+	TypeSyslogTCP = "syslog-tcp"
+	TypeSyslogTLS = "syslog-tls"
+	TypeLoki      = "loki"
+	// TypeElasticsearch sends to the bulk API of Elasticsearch or
+	// OpenSearch.
+	TypeElasticsearch = "elasticsearch"
+	TypeKafka         = "kafka"
+	TypeFile          = "file"
+)
+
+// Loki push formats.
+const (
+	LokiJSON     = "json"
+	LokiProtobuf = "protobuf"
+)
+
+// Loki line formats.
+const (
+	// LokiLineGELF sends the GELF payload as the line, so that the fields
+	// can be extracted with the json parser of LogQL.
+	LokiLineGELF = "gelf"
+	// LokiLineMessage sends the message only.
+	LokiLineMessage = "message"
+)
+
+// Elasticsearch bulk actions.
+const (
+	ElasticsearchIndex = "index"
+	// ElasticsearchCreate is required by the data streams.
+	ElasticsearchCreate = "create"
+)
+
+// Kafka acknowledgements, what the brokers wait for before acknowledging a
+// message.
+const (
+	KafkaAcksNone   = "none"
+	KafkaAcksLeader = "leader"
+	KafkaAcksAll    = "all"
+)
+
+// KafkaKeyNone sends the Kafka messages without a key, to random
+// partitions.
+const KafkaKeyNone = "none"
+
+// Kafka security protocols, named as in the configuration of Kafka.
+const (
+	KafkaPlaintext     = "plaintext"
+	KafkaSSL           = "ssl"
+	KafkaSASLPlaintext = "sasl_plaintext"
+	KafkaSASLSSL       = "sasl_ssl"
+)
+
+// Kafka compression codecs.
+const (
+	KafkaCompressionNone   = "none"
+	KafkaCompressionGzip   = "gzip"
+	KafkaCompressionSnappy = "snappy"
+	KafkaCompressionLZ4    = "lz4"
+)
+
+// File synchronization policies, how often the file destinations are
+// flushed to the disk.
+const (
+	// FileSyncNone leaves it to the operating system.
+	FileSyncNone = "none"
+	// FileSyncAlways flushes the file after every entry.
+	FileSyncAlways = "always"
+	// FileSyncInterval flushes the file once the sync interval elapsed
+	// since an entry was written.
+	FileSyncInterval = "interval"
+)
+
+// Syslog formats.
+const (
+	SyslogRFC5424 = "rfc5424"
+	SyslogRFC3164 = "rfc3164"
+)
+
+// Outputs holds the list of destinations the log entries are forwarded to,
+// and how they are dispatched between them.
+type Outputs struct {
+	Mode string `yaml:"mode"`
+	// RetryInterval is how long a destination that failed is considered
+	// unhealthy by the failover and loadbalance modes.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	Destinations  []Destination `yaml:"destinations"`
+}
+
+// Destination holds the parameters of a single output.
+type Destination struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Hostname   string `yaml:"hostname"`
+	Port       int    `yaml:"port"`
+	PacketSize int    `yaml:"packet_size,omitempty"`
+	Filter     Filter `yaml:"filter,omitempty"`
+	// URL is the address of the HTTP destinations, instead of the hostname
+	// and the port.
+	URL string `yaml:"url,omitempty"`
+	// Username and Password are the basic authentication credentials of the
+	// HTTP destinations.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	TLS      TLS    `yaml:"tls,omitempty"`
+	Batch    Batch  `yaml:"batch,omitempty"`
+	Syslog   Syslog `yaml:"syslog,omitempty"`
+	Loki     Loki   `yaml:"loki,omitempty"`
+
+	Elasticsearch Elasticsearch `yaml:"elasticsearch,omitempty"`
+	Kafka         Kafka         `yaml:"kafka,omitempty"`
+	AMQP          AMQP          `yaml:"amqp,omitempty"`
+	File          File          `yaml:"file,omitempty"`
+}
+
+// Batch holds the parameters of the batching of the destinations sending
+// several entries at once.
+type Batch struct {
+	// Size is the maximum number of entries of a batch.
+	Size int `yaml:"size,omitempty"`
+	// Bytes is the size of the payloads above which a batch is sent.
+	Bytes int `yaml:"bytes,omitempty"`
+	// Wait is how long an entry waits for the batch to be full.
+	Wait time.Duration `yaml:"wait,omitempty"`
+	// MaxRetries is the number of times a batch that failed is sent again,
+	// waiting RetryBackoff, doubled at every retry, in between. A negative
+	// value disables the retries.
+	MaxRetries   int           `yaml:"max_retries,omitempty"`
+	RetryBackoff time.Duration `yaml:"retry_backoff,omitempty"`
+}
+
+// Loki holds the parameters of the Grafana Loki destinations.
+type Loki struct {
+	// Format is json or protobuf, the latter being compressed with snappy.
+	Format string `yaml:"format,omitempty"`
+	// Labels are the labels of the streams, by name, along with what they
+	// are taken from: host, unit, priority, identifier, facility or
+	// transport, a journal field or an additional field.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// StaticLabels are added to all the streams.
+	StaticLabels map[string]string `yaml:"static_labels,omitempty"`
+	// Line is what the lines are made of: gelf or message.
+	Line string `yaml:"line,omitempty"`
+	// TenantID, if set, is sent in the X-Scope-OrgID header.
+	TenantID string `yaml:"tenant_id,omitempty"`
+}
+
+// Elasticsearch holds the parameters of the Elasticsearch and OpenSearch
+// destinations.
+type Elasticsearch struct {
+	// Index is the Go template of the names of the indices, executed with
+	// .Date (the UTC day of the entry, such as 2018.03.14), .Time and .Host.
+	Index string `yaml:"index,omitempty"`
+	// Action is index or create.
+	Action string `yaml:"action,omitempty"`
+	// Pipeline, if set, is the ingest pipeline the documents go through.
+	Pipeline string `yaml:"pipeline,omitempty"`
+}
+
+// Kafka holds the parameters of the Kafka destinations.
+type Kafka struct {
+	Brokers []string `yaml:"brokers,omitempty"`
+	Topic   string   `yaml:"topic,omitempty"`
+	// Key is what the partition keys of the messages are taken from, as
+	// the Loki labels, or none.
+	Key string `yaml:"key,omitempty"`
+	// Acks is none, leader or all.
+	Acks        string `yaml:"acks,omitempty"`
+	Compression string `yaml:"compression,omitempty"`
+	// Version is the version of the oldest broker, such as 2.0.0.
+	Version string `yaml:"version,omitempty"`
+	// SecurityProtocol is plaintext, ssl, sasl_plaintext or sasl_ssl, the
+	// SASL PLAIN credentials being the username and the password of the
+	// destination.
+	SecurityProtocol string `yaml:"security_protocol,omitempty"`
+}
+
+// AMQP holds the parameters of the GELF AMQP destinations.
+type AMQP struct {
+	Exchange   string `yaml:"exchange,omitempty"`
+	RoutingKey string `yaml:"routing_key,omitempty"`
+	// ExchangeType, if set, is the type of the exchange, which is then
+	// declared, durable, if it does not exist yet.
+	ExchangeType string `yaml:"exchange_type,omitempty"`
+}
+
+// File holds the parameters of the file destinations.
+type File struct {
+	Path string `yaml:"path,omitempty"`
+	// MaxSize is the size in bytes above which the file is rotated, and
+	// RotateInterval how long it is written to before being rotated, zero
+	// disabling either.
+	MaxSize        int64         `yaml:"max_size,omitempty"`
+	RotateInterval time.Duration `yaml:"rotate_interval,omitempty"`
+	// Compress gzips the rotated files.
+	Compress bool `yaml:"compress,omitempty"`
+	// Keep is the number of rotated files kept, zero keeping them all.
+	Keep int `yaml:"keep,omitempty"`
+	// Sync is none, always or interval.
+	Sync         string        `yaml:"sync,omitempty"`
+	SyncInterval time.Duration `yaml:"sync_interval,omitempty"`
+}
+
+// TLS holds the parameters of the TLS connections to a destination. The
+// certificates of the server are verified with the system roots, unless a
+// CA file is given.
+type TLS struct {
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertFile and KeyFile are the client certificate and its key, for the
+	// servers requiring one.
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// Syslog holds the parameters of the syslog destinations.
+type Syslog struct {
+	// Format is rfc5424 or rfc3164.
+	Format string `yaml:"format,omitempty"`
+	// SDID is the SD-ID of the RFC 5424 structured data element carrying
+	// the journal fields.
+	SDID string `yaml:"sd_id,omitempty"`
+	// MaxSize is the size above which the messages sent over UDP are
+	// truncated, as a datagram cannot be bigger than the network allows.
+	MaxSize int `yaml:"max_size,omitempty"`
+}
+
+// Filter selects the log entries sent to a destination, the regexes are
+// matched against the raw journald JSON log line. An entry is sent if it
+// matches at least one of the include regexes, when there are any, and none
+// of the exclude regexes.
+type Filter struct {
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// Default returns a configuration populated with the default values, those
+// are the values used when neither the configuration file, the environment
+// or the command line specify otherwise.
+func Default() *Config {
+	return &Config{
+		QueueSize: 1000,
+		Graylog: Graylog{
+			Port:       12201,
+			PacketSize: 1420,
+		},
+		Outputs: Outputs{
+			Mode:          ModeFanout,
+			RetryInterval: 30 * time.Second,
+		},
+		Health: Health{
+			InputTimeout:    time.Hour,
+			StallTimeout:    time.Minute,
+			ReadyQueueRatio: 0.9,
+		},
+		Input: Input{
+			MaxEntrySize: 1024 * 1024,
+			Oversize:     OversizeDrop,
+		},
+		Multiline: Multiline{
+			Continuation: `^(\s|Caused by:|\.\.\. \d+ (more|common frames omitted))`,
+			FlushTimeout: 2 * time.Second,
+			MaxLines:     500,
+		},
+		Docker: Docker{
+			JoinPartial:    true,
+			PartialMaxSize: 1024 * 1024,
+			PartialTimeout: 5 * time.Second,
+			Socket:         "/var/run/docker.sock",
+			CacheSize:      1000,
+		},
+		Kubernetes: Kubernetes{
+			Metadata: true,
+		},
+		JSON: JSON{
+			MaxDepth:      3,
+			MessageKeys:   []string{"msg", "message"},
+			LevelKeys:     []string{"level", "lvl", "severity"},
+			TimestampKeys: []string{"ts", "time", "timestamp", "@timestamp"},
+		},
+		GELF: GELF{
+			FacilityFormat: `{{if and .Facility .Identifier}}{{.Facility}} ({{.Identifier}}){{else if .Facility}}{{.Facility}}{{else if .Identifier}}{{.Identifier}}{{else}}Undefined{{end}}`,
+			EmptyMessages:  EmptyPlaceholder,
+			Placeholder:    "(empty message)",
+		},
+		Host: Host{
+			Strategy: HostJournal,
+		},
+		Timestamp: Timestamp{
+			Sources:   []string{journald.TimestampMessage, journald.TimestampSource, journald.TimestampRealtime},
+			MaxFuture: 24 * time.Hour,
+		},
+		Redact: Redact{
+			Detectors:   []string{"credit_card", "email", "bearer_token", "password"},
+			Mode:        RedactReplace,
+			Replacement: "[REDACTED]",
+		},
+		Logfmt: Logfmt{
+			MessageKeys:   []string{"msg", "message"},
+			LevelKeys:     []string{"level", "lvl", "severity"},
+			TimestampKeys: []string{"ts", "time", "timestamp"},
+		},
+		Log: Log{
+			Level:    "info",
+			Format:   logging.FormatText,
+			Facility: "journald2graylog",
+		},
+	}
+}
+
+// Load reads the YAML configuration file found at path and merges it on top
+// of cfg, settings absent from the file keep their current value.
+func Load(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration file %q: %s", path, err)
+	}
+	return nil
+}
+
+// Validate returns an error if the configuration is not usable.
+func (cfg *Config) Validate() error {
+	if _, err := logging.ParseLevel(cfg.Log.Level); err != nil {
+		return err
+	}
+	if cfg.Log.Format != logging.FormatText && cfg.Log.Format != logging.FormatJSON {
+		return fmt.Errorf("unknown log format %q", cfg.Log.Format)
+	}
+	if cfg.Input.MaxEntrySize <= 0 {
+		return fmt.Errorf("invalid maximum entry size %d", cfg.Input.MaxEntrySize)
+	}
+	if cfg.Input.Oversize != OversizeDrop && cfg.Input.Oversize != OversizeTruncate {
+		return fmt.Errorf("unknown oversize policy %q", cfg.Input.Oversize)
+	}
+	if cfg.Multiline.Enabled {
+		if cfg.Multiline.Start == "" && cfg.Multiline.Continuation == "" {
+			return fmt.Errorf("the multiline stage requires a start or a continuation regex")
+		}
+		if cfg.Multiline.FlushTimeout <= 0 {
+			return fmt.Errorf("invalid multiline flush timeout %s", cfg.Multiline.FlushTimeout)
+		}
+		if cfg.Multiline.MaxLines <= 0 {
+			return fmt.Errorf("invalid multiline maximum number of lines %d", cfg.Multiline.MaxLines)
+		}
+	}
+	if cfg.Docker.JoinPartial {
+		if cfg.Docker.PartialMaxSize <= 0 {
+			return fmt.Errorf("invalid partial message maximum size %d", cfg.Docker.PartialMaxSize)
+		}
+		if cfg.Docker.PartialTimeout <= 0 {
+			return fmt.Errorf("invalid partial message timeout %s", cfg.Docker.PartialTimeout)
+		}
+	}
+	if cfg.Docker.Enrich && cfg.Docker.CacheSize <= 0 {
+		return fmt.Errorf("invalid Docker cache size %d", cfg.Docker.CacheSize)
+	}
+	if cfg.Kubernetes.Enrich && cfg.Kubernetes.Node == "" {
+		return fmt.Errorf("the Kubernetes enrichment requires the name of the node")
+	}
+	if cfg.JSON.Enabled && cfg.JSON.MaxDepth <= 0 {
+		return fmt.Errorf("invalid JSON maximum depth %d", cfg.JSON.MaxDepth)
+	}
+	names := map[string]bool{}
+	for _, rule := range cfg.Grok.Rules {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("the grok rules must have unique names")
+		}
+		names[rule.Name] = true
+		if rule.Pattern == "" {
+			return fmt.Errorf("grok rule %q: the pattern MUST be specified", rule.Name)
+		}
+	}
+	if cfg.Redact.Enabled {
+		switch cfg.Redact.Mode {
+		case RedactReplace:
+		case RedactHash:
+			if cfg.Redact.KeyFile == "" {
+				return fmt.Errorf("the hash redaction mode requires a key file")
+			}
+		default:
+			return fmt.Errorf("unknown redaction mode %q", cfg.Redact.Mode)
+		}
+		for _, p := range cfg.Redact.Patterns {
+			if p.Name == "" || p.Pattern == "" {
+				return fmt.Errorf("the redaction patterns MUST have a name and a pattern")
+			}
+		}
+	}
+	if _, err := template.New("facility").Parse(cfg.GELF.FacilityFormat); err != nil {
+		return fmt.Errorf("invalid facility format: %s", err)
+	}
+	switch cfg.Host.Strategy {
+	case HostJournal, HostOS, HostFQDN, HostMachineID:
+	case HostFile:
+		if cfg.Host.File == "" && cfg.Host.Override == "" {
+			return fmt.Errorf("the file host strategy requires a file")
+		}
+	default:
+		return fmt.Errorf("unknown host strategy %q", cfg.Host.Strategy)
+	}
+	switch cfg.GELF.EmptyMessages {
+	case EmptyDrop:
+	case EmptyPlaceholder:
+		if strings.TrimSpace(cfg.GELF.Placeholder) == "" {
+			return fmt.Errorf("the placeholder of the empty messages MUST NOT be empty")
+		}
+	default:
+		return fmt.Errorf("unknown empty messages policy %q", cfg.GELF.EmptyMessages)
+	}
+	for name, kind := range cfg.GELF.FieldTypes {
+		if !gelf.ValidType(kind) {
+			return fmt.Errorf("unknown type %q of the field %q", kind, name)
+		}
+		if gelf.Reserved(name) {
+			return fmt.Errorf("the type of the standard field %q cannot be changed", name)
+		}
+	}
+	if len(cfg.Timestamp.Sources) == 0 {
+		return fmt.Errorf("at least one timestamp source MUST be specified")
+	}
+	for _, source := range cfg.Timestamp.Sources {
+		switch source {
+		case journald.TimestampMessage, journald.TimestampSource, journald.TimestampRealtime:
+		default:
+			return fmt.Errorf("unknown timestamp source %q", source)
+		}
+	}
+	if cfg.Timestamp.MaxFuture < 0 {
+		return fmt.Errorf("invalid maximum timestamp future %s", cfg.Timestamp.MaxFuture)
+	}
+	if cfg.QueueSize < 0 {
+		return fmt.Errorf("invalid queue size %d", cfg.QueueSize)
+	}
+	if cfg.Health.ReadyQueueRatio <= 0 || cfg.Health.ReadyQueueRatio > 1 {
+		return fmt.Errorf("invalid ready queue ratio %g, it must be between 0 and 1", cfg.Health.ReadyQueueRatio)
+	}
+	switch cfg.Outputs.Mode {
+	case ModeFanout, ModeFailover, ModeLoadBalance:
+	default:
+		return fmt.Errorf("unknown output mode %q", cfg.Outputs.Mode)
+	}
+	if len(cfg.Outputs.Destinations) == 0 && cfg.Graylog.Hostname == "" {
+		return fmt.Errorf("the Graylog hostname has no default and MUST be specified")
+	}
+	for _, d := range cfg.Destinations() {
+		err := d.validate()
+		if err != nil {
+			return fmt.Errorf("destination %q: %s", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// Destinations returns the destinations declared in the configuration file,
+// or, if there are none, a single GELF UDP destination built from the
+// Graylog parameters.
+func (cfg *Config) Destinations() []Destination {
+	if len(cfg.Outputs.Destinations) > 0 {
+		destinations := make([]Destination, len(cfg.Outputs.Destinations))
+		for i, d := range cfg.Outputs.Destinations {
+			if d.Type == "" {
+				d.Type = TypeGELFUDP
+			}
+			if d.Name == "" {
+				d.Name = fmt.Sprintf("%s-%d", d.Type, i)
+			}
+			if d.Port == 0 {
+				d.Port = defaultPorts[d.Type]
+			}
+			if d.Port == 0 && hostTypes[d.Type] {
+				d.Port = cfg.Graylog.Port
+			}
+			switch d.Type {
+			case TypeSyslogUDP, TypeSyslogTCP, TypeSyslogTLS:
+				if d.Syslog.Format == "" {
+					d.Syslog.Format = SyslogRFC5424
+				}
+				if d.Syslog.SDID == "" {
+					d.Syslog.SDID = "journal@32473"
+				}
+				if d.Syslog.MaxSize == 0 && d.Type == TypeSyslogUDP {
+					d.Syslog.MaxSize = 1024
+				}
+			case TypeLoki:
+				if d.Loki.Format == "" {
+					d.Loki.Format = LokiProtobuf
+				}
+				if d.Loki.Labels == nil {
+					d.Loki.Labels = map[string]string{"host": "host", "unit": "unit", "priority": "priority"}
+				}
+				if d.Loki.Line == "" {
+					d.Loki.Line = LokiLineGELF
+				}
+			case TypeElasticsearch:
+				if d.Elasticsearch.Index == "" {
+					d.Elasticsearch.Index = "journald-{{.Date}}"
+				}
+				if d.Elasticsearch.Action == "" {
+					d.Elasticsearch.Action = ElasticsearchIndex
+				}
+			case TypeGELFAMQP:
+				if d.AMQP.Exchange == "" {
+					d.AMQP.Exchange = "log-messages"
+				}
+				if d.AMQP.RoutingKey == "" {
+					d.AMQP.RoutingKey = "log-messages"
+				}
+			case TypeKafka:
+				if d.Kafka.Key == "" {
+					d.Kafka.Key = "host"
+				}
+				if d.Kafka.Acks == "" {
+					d.Kafka.Acks = KafkaAcksAll
+				}
+				if d.Kafka.Compression == "" {
+					d.Kafka.Compression = KafkaCompressionNone
+				}
+				if d.Kafka.Version == "" {
+					d.Kafka.Version = "1.0.0"
+				}
+				if d.Kafka.SecurityProtocol == "" {
+					d.Kafka.SecurityProtocol = KafkaPlaintext
+				}
+			case TypeFile:
+				if d.File.Sync == "" {
+					d.File.Sync = FileSyncInterval
+				}
+				if d.File.SyncInterval == 0 {
+					d.File.SyncInterval = time.Second
+				}
+			}
+			if batchTypes[d.Type] {
+				d.Batch = d.Batch.withDefaults()
+			}
+			if d.PacketSize == 0 && hostTypes[d.Type] {
+				d.PacketSize = cfg.Graylog.PacketSize
+			}
+			destinations[i] = d
+		}
+		return destinations
+	}
+	return []Destination{{
+		Name:       "graylog",
+		Type:       TypeGELFUDP,
+		Hostname:   cfg.Graylog.Hostname,
+		Port:       cfg.Graylog.Port,
+		PacketSize: cfg.Graylog.PacketSize,
+	}}
+}
+
+// defaultPorts are the ports of the destinations by type, the GELF ones
+// default to the Graylog port.
+var defaultPorts = map[string]int{
+	TypeSyslogUDP: 514,
+	TypeSyslogTCP: 514,
+	TypeSyslogTLS: 6514,
+}
+
+// hostTypes are the types of the destinations reached at a hostname and a
+// port.
+var hostTypes = map[string]bool{
+	TypeGELFUDP:   true,
+	TypeGELFTCP:   true,
+	TypeSyslogUDP: true,
+	TypeSyslogTCP: true,
+	TypeSyslogTLS: true,
+}
+
+// urlSchemes are the schemes of the URLs of the destinations reached at an
+// URL, by type.
+var urlSchemes = map[string][]string{
+	TypeLoki:          {"http", "https"},
+	TypeElasticsearch: {"http", "https"},
+	TypeGELFAMQP:      {"amqp", "amqps"},
+}
+
+// batchTypes are the types of the destinations sending the entries in
+// batches.
+var batchTypes = map[string]bool{
+	TypeLoki:          true,
+	TypeElasticsearch: true,
+	TypeKafka:         true,
+	TypeGELFAMQP:      true,
+}
+
+// withDefaults returns the batch parameters, with the defaults of those that
+// are not set.
+func (b Batch) withDefaults() Batch {
+	if b.Size == 0 {
+		b.Size = 1000
+	}
+	if b.Bytes == 0 {
+		b.Bytes = 1 << 20
+	}
+	if b.Wait == 0 {
+		b.Wait = time.Second
+	}
+	if b.MaxRetries == 0 {
+		b.MaxRetries = 5
+	}
+	if b.RetryBackoff == 0 {
+		b.RetryBackoff = 500 * time.Millisecond
+	}
+	return b
+}
+
+// labelName matches the valid Loki label names.
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (d *Destination) validate() error {
+	switch d.Type {
+	case TypeGELFUDP, TypeGELFTCP:
+	case TypeSyslogUDP, TypeSyslogTCP, TypeSyslogTLS:
+		if d.Syslog.Format != SyslogRFC5424 && d.Syslog.Format != SyslogRFC3164 {
+			return fmt.Errorf("unknown syslog format %q", d.Syslog.Format)
+		}
+		// Every syslog receiver accepts 480 bytes long messages, and a UDP
+		// datagram cannot hold more than 65507 bytes.
+		if d.Type == TypeSyslogUDP && (d.Syslog.MaxSize < 480 || d.Syslog.MaxSize > 65507) {
+			return fmt.Errorf("invalid syslog maximum size %d, it must be between 480 and 65507", d.Syslog.MaxSize)
+		}
+	case TypeLoki:
+		if d.Loki.Format != LokiJSON && d.Loki.Format != LokiProtobuf {
+			return fmt.Errorf("unknown Loki format %q", d.Loki.Format)
+		}
+		if d.Loki.Line != LokiLineGELF && d.Loki.Line != LokiLineMessage {
+			return fmt.Errorf("unknown Loki line format %q", d.Loki.Line)
+		}
+		if len(d.Loki.Labels)+len(d.Loki.StaticLabels) == 0 {
+			return fmt.Errorf("at least one Loki label MUST be specified")
+		}
+		for name, source := range d.Loki.Labels {
+			if !labelName.MatchString(name) || source == "" {
+				return fmt.Errorf("invalid Loki label %q", name)
+			}
+		}
+		for name := range d.Loki.StaticLabels {
+			if !labelName.MatchString(name) {
+				return fmt.Errorf("invalid Loki label %q", name)
+			}
+		}
+	case TypeElasticsearch:
+		if _, err := template.New("index").Parse(d.Elasticsearch.Index); err != nil {
+			return fmt.Errorf("invalid index template: %s", err)
+		}
+		if d.Elasticsearch.Action != ElasticsearchIndex && d.Elasticsearch.Action != ElasticsearchCreate {
+			return fmt.Errorf("unknown bulk action %q", d.Elasticsearch.Action)
+		}
+	case TypeGELFAMQP:
+	case TypeKafka:
+		if len(d.Kafka.Brokers) == 0 || d.Kafka.Topic == "" {
+			return fmt.Errorf("the Kafka brokers and topic MUST be specified")
+		}
+		switch d.Kafka.Acks {
+		case KafkaAcksNone, KafkaAcksLeader, KafkaAcksAll:
+		default:
+			return fmt.Errorf("unknown Kafka acks %q", d.Kafka.Acks)
+		}
+		switch d.Kafka.Compression {
+		case KafkaCompressionNone, KafkaCompressionGzip, KafkaCompressionSnappy, KafkaCompressionLZ4:
+		default:
+			return fmt.Errorf("unknown Kafka compression %q", d.Kafka.Compression)
+		}
+		switch d.Kafka.SecurityProtocol {
+		case KafkaPlaintext, KafkaSSL:
+		case KafkaSASLPlaintext, KafkaSASLSSL:
+			if d.Username == "" || d.Password == "" {
+				return fmt.Errorf("the SASL username and password MUST be specified")
+			}
+		default:
+			return fmt.Errorf("unknown Kafka security protocol %q", d.Kafka.SecurityProtocol)
+		}
+	case TypeFile:
+		if d.File.Path == "" {
+			return fmt.Errorf("the path MUST be specified")
+		}
+		if d.File.MaxSize < 0 || d.File.RotateInterval < 0 || d.File.Keep < 0 {
+			return fmt.Errorf("invalid rotation parameters")
+		}
+		switch d.File.Sync {
+		case FileSyncNone, FileSyncAlways:
+		case FileSyncInterval:
+			if d.File.SyncInterval <= 0 {
+				return fmt.Errorf("invalid sync interval %s", d.File.SyncInterval)
+			}
+		default:
+			return fmt.Errorf("unknown sync policy %q", d.File.Sync)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", d.Type)
+	}
+	if (d.TLS.CertFile == "") != (d.TLS.KeyFile == "") {
+		return fmt.Errorf("the TLS certificate and key MUST be specified together")
+	}
+	if batchTypes[d.Type] {
+		if schemes, ok := urlSchemes[d.Type]; ok {
+			u, err := url.Parse(d.URL)
+			if err != nil || u.Host == "" || (u.Scheme != schemes[0] && u.Scheme != schemes[1]) {
+				return fmt.Errorf("invalid URL %q", d.URL)
+			}
+		}
+		if d.Batch.Size <= 0 || d.Batch.Bytes <= 0 || d.Batch.Wait <= 0 || d.Batch.RetryBackoff <= 0 {
+			return fmt.Errorf("invalid batch parameters")
+		}
+	}
+	if !hostTypes[d.Type] {
+		return nil
+	}
+	if d.Hostname == "" {
+		return fmt.Errorf("the hostname MUST be specified")
+	}
+	if d.Port <= 0 || d.Port > 65535 {
+		return fmt.Errorf("invalid port %d", d.Port)
+	}
+	if d.PacketSize <= 0 {
+		return fmt.Errorf("invalid packet size %d", d.PacketSize)
+	}
+	return nil
+}
+
+// secretMask replaces the secrets in the dump of the configuration.
+const secretMask = "******"
+
+// Dump returns the YAML representation of the configuration, the passwords
+// of the destinations, including those of their URLs, being masked.
+func (cfg *Config) Dump() (string, error) {
+	masked := *cfg
+	masked.Outputs.Destinations = make([]Destination, len(cfg.Outputs.Destinations))
+	for i, d := range cfg.Outputs.Destinations {
+		if d.Password != "" {
+			d.Password = secretMask
+		}
+		if u, err := url.Parse(d.URL); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), secretMask)
+				d.URL = u.String()
+			}
+		}
+		masked.Outputs.Destinations[i] = d
+	}
+	out, err := yaml.Marshal(&masked)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// ListValue is a kingpin compatible value that fills a list of strings from
+// a single semicolon separated command line or environment value ( e.g. :
+// "foo.*;bar.*" ).
+type ListValue struct {
+	List *[]string
+}
+
+// Set replaces the list with the semicolon separated elements of value.
+func (v ListValue) Set(value string) error {
+	list := []string{}
+	for _, element := range strings.Split(value, ";") {
+		if len(element) > 0 {
+			list = append(list, element)
+		}
+	}
+	*v.List = list
+	return nil
+}
+
+func (v ListValue) String() string {
+	if v.List == nil {
+		return ""
+	}
+	return strings.Join(*v.List, ";")
+}
@@ -0,0 +1,172 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cdemers/journald2graylog/gelf"
+	"github.com/cdemers/journald2graylog/journald"
+	"github.com/cdemers/journald2graylog/logging"
+)
+
+const (
+	dockerTimeout = 2 * time.Second
+	// retryDelay is how long the failed lookups are cached, and how long
+	// the daemon is not queried at all once it could not be reached, so
+	// that an unresponsive daemon only delays one entry every retryDelay.
+	retryDelay = 30 * time.Second
+)
+
+// Metadata is what the Docker daemon tells about a container.
+type Metadata struct {
+	Image   string
+	ImageID string
+	Labels  map[string]string
+}
+
+// Enricher adds the image and the labels of the containers, as returned by
+// the Docker daemon, to the entries logged by Docker's journald logging
+// driver. The metadata is cached per container ID.
+type Enricher struct {
+	client    *http.Client
+	labels    map[string]bool
+	cacheSize int
+	cache     map[string]cached
+	// unreachableUntil is when the daemon is queried again, after it could
+	// not be reached.
+	unreachableUntil time.Time
+	now              func() time.Time
+}
+
+// cached is the metadata of a container, nil if it is unknown or its lookup
+// failed, in which case it expires.
+type cached struct {
+	metadata *Metadata
+	expires  time.Time
+}
+
+// NewEnricher returns an enricher querying the Docker daemon listening on
+// the given unix socket. Only the labels listed are added, or all of them if
+// none are, and at most cacheSize containers are cached.
+func NewEnricher(socket string, labels []string, cacheSize int) *Enricher {
+	e := &Enricher{
+		client: &http.Client{
+			Timeout: dockerTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		cacheSize: cacheSize,
+		cache:     map[string]cached{},
+		now:       time.Now,
+	}
+	if len(labels) > 0 {
+		e.labels = map[string]bool{}
+		for _, label := range labels {
+			e.labels[label] = true
+		}
+	}
+	return e
+}
+
+// Enrich adds the metadata of the container that logged the entry, if any,
+// to the record.
+func (e *Enricher) Enrich(r *journald.Record) {
+	id := r.Entry.ContainerIDFull
+	if id == "" {
+		id = r.Entry.ContainerID
+	}
+	if id == "" {
+		return
+	}
+
+	metadata := e.lookup(id)
+	if metadata == nil {
+		return
+	}
+
+	if metadata.Image != "" {
+		r.SetField("ContainerImage", metadata.Image)
+	}
+	if metadata.ImageID != "" {
+		r.SetField("ContainerImageID", metadata.ImageID)
+	}
+	for label, value := range metadata.Labels {
+		if e.labels == nil || e.labels[label] {
+			r.SetField(gelf.FieldName("ContainerLabel_"+label), value)
+		}
+	}
+}
+
+// lookup returns the metadata of a container, from the cache or from the
+// Docker daemon. The containers are short lived and their metadata never
+// changes, so the cache is simply reset once it is full.
+func (e *Enricher) lookup(id string) *Metadata {
+	now := e.now()
+	entry, ok := e.cache[id]
+	if ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		return entry.metadata
+	}
+	if now.Before(e.unreachableUntil) {
+		return nil
+	}
+
+	metadata, err := e.inspect(id)
+	entry = cached{metadata: metadata}
+	if err != nil {
+		logging.WithFields(logging.Fields{"container": id, "error": err}).Warnf("Could not inspect the container.")
+		if _, ok := err.(*url.Error); ok {
+			// The daemon could not be reached, it is not queried for the
+			// other containers either until it is retried.
+			e.unreachableUntil = now.Add(retryDelay)
+			return nil
+		}
+		entry.expires = now.Add(retryDelay)
+	}
+	if len(e.cache) >= e.cacheSize {
+		e.cache = map[string]cached{}
+	}
+	e.cache[id] = entry
+	return metadata
+}
+
+// inspect queries the Docker daemon about a container, it returns nil if the
+// container is unknown.
+func (e *Enricher) inspect(id string) (*Metadata, error) {
+	response, err := e.client.Get("http://docker/containers/" + url.PathEscape(id) + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the Docker daemon answered %s", response.Status)
+	}
+
+	var container struct {
+		Image  string
+		Config struct {
+			Image  string
+			Labels map[string]string
+		}
+	}
+	err = json.NewDecoder(response.Body).Decode(&container)
+	if err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		Image:   container.Config.Image,
+		ImageID: container.Image,
+		Labels:  container.Config.Labels,
+	}, nil
+}
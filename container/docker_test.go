@@ -0,0 +1,122 @@
+package container
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// fakeDocker serves the container inspection API over a unix socket.
+func fakeDocker(t *testing.T, requests *int) (socket string, stop func()) {
+	dir, err := ioutil.TempDir("", "journald2graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket = filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.URL.Path == "/containers/broken/json" {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/containers/0123456789ab/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"Image":"sha256:abcd","Config":{"Image":"nginx:1.13","Labels":{"app":"web","com.example.team":"ops"}}}`))
+	}))
+	server.Listener = listener
+	server.Start()
+	return socket, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	requests := 0
+	socket, stop := fakeDocker(t, &requests)
+	defer stop()
+
+	e := NewEnricher(socket, []string{"app"}, 10)
+	for i := 0; i < 2; i++ {
+		r := &journald.Record{Entry: journald.JournaldJSONLogEntry{ContainerID: "0123456789ab"}}
+		e.Enrich(r)
+
+		if r.Fields["ContainerImage"] != "nginx:1.13" || r.Fields["ContainerImageID"] != "sha256:abcd" {
+			t.Errorf("unexpected fields %v", r.Fields)
+		}
+		if r.Fields["_ContainerLabel_app"] != "web" {
+			t.Errorf("the app label should be added, got %v", r.Fields)
+		}
+		if _, ok := r.Fields["_ContainerLabel_com.example.team"]; ok {
+			t.Error("the labels that are not listed should not be added")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("the metadata should be cached, got %d requests", requests)
+	}
+
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{ContainerID: "unknown"}}
+	e.Enrich(r)
+	if r.Fields != nil {
+		t.Errorf("an unknown container should not add fields, got %v", r.Fields)
+	}
+}
+
+func TestEnrichFailures(t *testing.T) {
+	requests := 0
+	socket, stop := fakeDocker(t, &requests)
+
+	now := time.Date(2018, 3, 14, 15, 9, 26, 0, time.UTC)
+	e := NewEnricher(socket, nil, 10)
+	e.now = func() time.Time { return now }
+	enrich := func(id string) map[string]interface{} {
+		r := &journald.Record{Entry: journald.JournaldJSONLogEntry{ContainerID: id}}
+		e.Enrich(r)
+		return r.Fields
+	}
+
+	// The failed lookups are cached for a while.
+	for i := 0; i < 2; i++ {
+		if fields := enrich("broken"); fields != nil {
+			t.Errorf("a failed lookup should not add fields, got %v", fields)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("the failure should be cached, got %d requests", requests)
+	}
+	now = now.Add(retryDelay)
+	enrich("broken")
+	if requests != 2 {
+		t.Errorf("the failure should expire, got %d requests", requests)
+	}
+
+	// Once the daemon cannot be reached, it is no longer queried until it
+	// is retried.
+	stop()
+	enrich("0123456789ab")
+	started := time.Now()
+	for i := 0; i < 100; i++ {
+		if fields := enrich("0123456789ab"); fields != nil {
+			t.Fatalf("an unreachable daemon should not add fields, got %v", fields)
+		}
+	}
+	if time.Since(started) > time.Second || !e.unreachableUntil.Equal(now.Add(retryDelay)) {
+		t.Error("the unreachable daemon should not be queried again")
+	}
+	if _, ok := e.cache["0123456789ab"]; ok {
+		t.Error("the containers should not be cached while the daemon is unreachable")
+	}
+}
@@ -0,0 +1,123 @@
+package container
+
+import (
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Assembler joins the fragments of the lines that Docker's journald logging
+// driver splits into several entries flagged CONTAINER_PARTIAL_MESSAGE=true,
+// the last fragment of a line not being flagged.
+type Assembler struct {
+	maxSize  int
+	timeout  time.Duration
+	pending  map[string]*partial
+	sequence int
+}
+
+// partial is a line being assembled.
+type partial struct {
+	record  *journald.Record
+	message []byte
+	last    time.Time
+	// sequence orders the partial lines by creation.
+	sequence int
+}
+
+// NewAssembler returns an assembler joining lines up to maxSize bytes, the
+// remaining fragments being dropped, and sending incomplete lines once no
+// fragment was added to them for the timeout.
+func NewAssembler(maxSize int, timeout time.Duration) *Assembler {
+	return &Assembler{
+		maxSize: maxSize,
+		timeout: timeout,
+		pending: map[string]*partial{},
+	}
+}
+
+// Add gives a new record to the assembler, and returns the records that are
+// complete because of it.
+func (a *Assembler) Add(r *journald.Record, now time.Time) []*journald.Record {
+	id := r.Entry.ContainerID
+	p := a.pending[id]
+	isPartial := r.Entry.ContainerPartialMessage == "true"
+
+	if id == "" || (p == nil && !isPartial) {
+		return []*journald.Record{r}
+	}
+
+	if p == nil {
+		a.sequence++
+		p = &partial{record: r, sequence: a.sequence}
+		a.pending[id] = p
+	} else if len(p.message) <= a.maxSize {
+		p.record.Line = append(append(p.record.Line, '\n'), r.Line...)
+	}
+	p.last = now
+	p.record.Truncated = p.record.Truncated || r.Truncated
+	// Keep a few more bytes than the maximum size, so that the message can
+	// be truncated without splitting a character once it is complete.
+	message := r.Entry.Message
+	if room := a.maxSize + utf8.UTFMax - len(p.message); room < len(message) {
+		if room < 0 {
+			room = 0
+		}
+		message = message[:room]
+	}
+	p.message = append(p.message, message...)
+
+	if isPartial {
+		return nil
+	}
+	delete(a.pending, id)
+	return []*journald.Record{a.finish(p)}
+}
+
+// Flush returns the incomplete records to which no fragment was added for
+// the timeout.
+func (a *Assembler) Flush(now time.Time) []*journald.Record {
+	var complete []*journald.Record
+	for _, id := range a.ids() {
+		if now.Sub(a.pending[id].last) >= a.timeout {
+			complete = append(complete, a.finish(a.pending[id]))
+			delete(a.pending, id)
+		}
+	}
+	return complete
+}
+
+// FlushAll returns all the records being assembled.
+func (a *Assembler) FlushAll() []*journald.Record {
+	var complete []*journald.Record
+	for _, id := range a.ids() {
+		complete = append(complete, a.finish(a.pending[id]))
+		delete(a.pending, id)
+	}
+	return complete
+}
+
+// ids returns the container IDs of the pending records, in their creation
+// order.
+func (a *Assembler) ids() []string {
+	ids := make([]string, 0, len(a.pending))
+	for id := range a.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return a.pending[ids[i]].sequence < a.pending[ids[j]].sequence
+	})
+	return ids
+}
+
+// finish returns the record with the joined message.
+func (a *Assembler) finish(p *partial) *journald.Record {
+	p.record.Entry.Message = string(p.message)
+	p.record.Entry.ContainerPartialMessage = ""
+	if p.record.Entry.TruncateMessage(a.maxSize) {
+		p.record.Truncated = true
+	}
+	return p.record
+}
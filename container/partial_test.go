@@ -0,0 +1,64 @@
+package container
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+func fragment(id, message string, partial bool) *journald.Record {
+	r := &journald.Record{
+		Entry: journald.JournaldJSONLogEntry{ContainerID: id, Message: message},
+		Line:  []byte(message),
+	}
+	if partial {
+		r.Entry.ContainerPartialMessage = "true"
+	}
+	return r
+}
+
+func TestAssemble(t *testing.T) {
+	a := NewAssembler(1000, time.Second)
+	now := time.Now()
+
+	if complete := a.Add(fragment("", "not a container", false), now); len(complete) != 1 {
+		t.Error("an entry without container should be passed through")
+	}
+	if complete := a.Add(fragment("b", "whole line", false), now); len(complete) != 1 {
+		t.Error("a complete line should be passed through")
+	}
+
+	a.Add(fragment("a", "first ", true), now)
+	a.Add(fragment("b", "other ", true), now)
+	a.Add(fragment("a", "second ", true), now)
+	complete := a.Add(fragment("a", "last", false), now)
+	if len(complete) != 1 || complete[0].Entry.Message != "first second last" {
+		t.Fatalf("unexpected records %v", complete)
+	}
+	if complete[0].Entry.ContainerPartialMessage != "" {
+		t.Error("the joined record should not be flagged as partial")
+	}
+
+	if complete := a.Flush(now.Add(500 * time.Millisecond)); len(complete) != 0 {
+		t.Error("the incomplete line should wait for the timeout")
+	}
+	complete = a.Flush(now.Add(time.Second))
+	if len(complete) != 1 || complete[0].Entry.Message != "other " {
+		t.Errorf("the incomplete line should be sent after the timeout, got %v", complete)
+	}
+}
+
+func TestAssembleMaxSize(t *testing.T) {
+	a := NewAssembler(10, time.Second)
+	now := time.Now()
+
+	a.Add(fragment("a", strings.Repeat("x", 8), true), now)
+	a.Add(fragment("a", strings.Repeat("y", 8), true), now)
+	complete := a.Add(fragment("a", strings.Repeat("z", 8), false), now)
+
+	if len(complete) != 1 || complete[0].Entry.Message != "xxxxxxxxyy" || !complete[0].Truncated {
+		t.Errorf("the message should be truncated to the maximum size, got %v", complete)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// facilityData is what the facility template is executed with.
+type facilityData struct {
+	// Facility is the name of the syslog facility, such as daemon.
+	Facility string
+	// FacilityNumber is the syslog facility, as logged.
+	FacilityNumber string
+	// Identifier is the syslog identifier, or, for the entries of the Docker
+	// containers without one, their tag or their name.
+	Identifier string
+	Unit       string
+}
+
+// formatFacility returns the GELF facility of the record.
+func formatFacility(t *template.Template, record *journald.Record) string {
+	entry := &record.Entry
+	data := facilityData{
+		Facility:       journald.FacilityName(entry.SyslogFacility),
+		FacilityNumber: entry.SyslogFacility,
+		Identifier:     entry.Identifier(),
+		Unit:           entry.SystemdUnit,
+	}
+
+	var facility bytes.Buffer
+	err := t.Execute(&facility, data)
+	if err != nil {
+		return ""
+	}
+	return facility.String()
+}
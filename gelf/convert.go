@@ -0,0 +1,101 @@
+package gelf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Types the additional fields can be converted to.
+const (
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeString = "string"
+	TypeBool   = "bool"
+)
+
+// ValidType returns true if kind is one of the types the additional fields
+// can be converted to.
+func ValidType(kind string) bool {
+	switch kind {
+	case TypeInt, TypeFloat, TypeString, TypeBool:
+		return true
+	}
+	return false
+}
+
+// ParseInt parses the decimal number of a numeric journal field, such as
+// _PID or CODE_LINE. It returns nil if the value is not a number.
+func ParseInt(value string) *int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// ConvertFields converts the additional fields whose GELF name is in types
+// to their type. The values that cannot be converted are left unchanged.
+func ConvertFields(fields map[string]interface{}, types map[string]string) {
+	if len(fields) == 0 || len(types) == 0 {
+		return
+	}
+	for name, value := range fields {
+		if kind, ok := types[FieldName(name)]; ok {
+			fields[name] = Convert(value, kind)
+		}
+	}
+}
+
+// Convert returns the value converted to a type, or unchanged if it cannot
+// be.
+func Convert(value interface{}, kind string) interface{} {
+	if kind == TypeString {
+		switch v := value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = strings.TrimSpace(v)
+	case json.Number:
+		text = string(v)
+	case int:
+		text = strconv.Itoa(v)
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return value
+	}
+	switch kind {
+	case TypeInt:
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+		// The integral floats, such as 1e3 or 200.0, are integers too.
+		if f, err := strconv.ParseFloat(text, 64); err == nil && f == float64(int64(f)) {
+			return int64(f)
+		}
+	case TypeFloat:
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case TypeBool:
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return value
+}
@@ -0,0 +1,64 @@
+package gelf
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseInt(t *testing.T) {
+	if n := ParseInt("42"); n == nil || *n != 42 {
+		t.Errorf("ParseInt(\"42\") = %v, expected 42", n)
+	}
+	for _, value := range []string{"", "4x2", "1.5"} {
+		if n := ParseInt(value); n != nil {
+			t.Errorf("ParseInt(%q) = %d, expected nil", value, *n)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		kind     string
+		expected interface{}
+	}{
+		{"200", TypeInt, int64(200)},
+		{" 200 ", TypeInt, int64(200)},
+		{json.Number("1e3"), TypeInt, int64(1000)},
+		{1.5, TypeInt, 1.5},
+		{"abc", TypeInt, "abc"},
+		{"0.25", TypeFloat, 0.25},
+		{int64(3), TypeFloat, 3.0},
+		{json.Number("01234"), TypeString, "01234"},
+		{int64(1234), TypeString, "1234"},
+		{2.5, TypeString, "2.5"},
+		{true, TypeString, "true"},
+		{"true", TypeBool, true},
+		{"yes", TypeBool, "yes"},
+		{[]interface{}{1}, TypeInt, []interface{}{1}},
+	}
+	for _, test := range tests {
+		actual := Convert(test.value, test.kind)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Convert(%#v, %s) = %#v, expected %#v", test.value, test.kind, actual, test.expected)
+		}
+	}
+}
+
+func TestConvertFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"status":   "404",
+		"_latency": "0.5",
+		"path":     "/index.html",
+	}
+	ConvertFields(fields, map[string]string{"_status": TypeInt, "_latency": TypeFloat})
+	expected := map[string]interface{}{
+		"status":   int64(404),
+		"_latency": 0.5,
+		"path":     "/index.html",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("got %#v, expected %#v", fields, expected)
+	}
+}
@@ -1,38 +1,153 @@
 package gelf
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Version is the version of GELF the entries are sent with.
+const Version = "1.1"
 
 // GELFLogEntry is the structure that maps all the GELF fields that will be
-// sent to the Graylog server.
+// sent to the Graylog server. The optional fields are left out when empty.
 type GELFLogEntry struct {
 	// Standard GELF Fields
 	Version      string  `json:"version"`
 	Host         string  `json:"host"`
 	ShortMessage string  `json:"short_message"`
-	FullMessage  string  `json:"full_message"`
+	FullMessage  string  `json:"full_message,omitempty"`
 	Timestamp    float64 `json:"timestamp"`
 	Level        int     `json:"level"`
-	Facility     string  `json:"facility"`
-	Line         *int    `json:"line"`
-	File         string  `json:"file"`
+	Facility     string  `json:"facility,omitempty"`
+	Line         *int    `json:"line,omitempty"`
+	File         string  `json:"file,omitempty"`
+
+	// LevelName is the name of the syslog severity of the level.
+	LevelName string `json:"_level_name,omitempty"`
 
 	// Systemd Extended Fields
-	BootID    string `json:"_BootID"`
-	MachineID string `json:"_MachineID"`
-	UID       string `json:"_UID"`
-	GID       string `json:"_GID"`
-	PID       string `json:"_PID"`
+	BootID    string `json:"_BootID,omitempty"`
+	MachineID string `json:"_MachineID,omitempty"`
+	UID       *int   `json:"_UID,omitempty"`
+	GID       *int   `json:"_GID,omitempty"`
+	PID       *int   `json:"_PID,omitempty"`
+
+	Command     string `json:"_Command,omitempty"`
+	Executable  string `json:"_Executable,omitempty"`
+	CommandLine string `json:"_CommandLine,omitempty"`
 
-	Command     string `json:"_Command"`
-	Executable  string `json:"_Executable"`
-	CommandLine string `json:"_CommandLine"`
+	Transport string `json:"_LogTransport,omitempty"`
 
-	Transport string `json:"_LogTransport"`
+	Function string `json:"_function,omitempty"`
 
-	Function string `json:"_function"`
+	// Docker Fields
+	ContainerID     string `json:"_ContainerID,omitempty"`
+	ContainerIDFull string `json:"_ContainerIDFull,omitempty"`
+	ContainerName   string `json:"_ContainerName,omitempty"`
+	ContainerTag    string `json:"_ContainerTag,omitempty"`
+	ImageName       string `json:"_ImageName,omitempty"`
 
 	// Metadata
-	RawLogLine string `json:"_RawLogLine"`
+	RawLogLine string `json:"_RawLogLine,omitempty"`
+	Truncated  bool   `json:"_truncated,omitempty"`
+
+	// AdditionalFields are sent along with the fields above, their names are
+	// prefixed with an underscore if they are not already, and those that
+	// would override one of the fields above are ignored.
+	AdditionalFields map[string]interface{} `json:"-"`
+}
+
+// invalidFieldCharacters matches the characters GELF does not allow in the
+// name of a field.
+var invalidFieldCharacters = regexp.MustCompile(`[^\w\.\-]`)
+
+// FieldName returns a valid GELF additional field name built from name.
+func FieldName(name string) string {
+	name = invalidFieldCharacters.ReplaceAllString(name, "_")
+	if !strings.HasPrefix(name, "_") {
+		name = "_" + name
+	}
+	return name
+}
+
+// reservedFields are the names of the fields of GELFLogEntry, along with
+// "_id" which GELF forbids.
+var reservedFields = func() map[string]bool {
+	reserved := map[string]bool{"_id": true}
+	t := reflect.TypeOf(GELFLogEntry{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			reserved[name] = true
+		}
+	}
+	return reserved
+}()
+
+// Reserved returns true if name is one of the standard fields of the
+// entries, such as level or _PID, rather than an additional field.
+func Reserved(name string) bool {
+	return reservedFields[name] || reservedFields[FieldName(name)]
+}
+
+// MarshalJSON encodes the entry along with its additional fields.
+func (log GELFLogEntry) MarshalJSON() ([]byte, error) {
+	type entry GELFLogEntry
+	output, err := json.Marshal(entry(log))
+	if err != nil || len(log.AdditionalFields) == 0 {
+		return output, err
+	}
+
+	additional := map[string]interface{}{}
+	for name, value := range log.AdditionalFields {
+		name = FieldName(name)
+		if !reservedFields[name] {
+			additional[name] = value
+		}
+	}
+	if len(additional) == 0 {
+		return output, nil
+	}
+	extra, err := json.Marshal(additional)
+	if err != nil {
+		return nil, err
+	}
+	// Splice the additional fields in the object of the standard ones.
+	output = append(output[:len(output)-1], ',')
+	return append(output, extra[1:]...), nil
+}
+
+// Validate checks that the entry has the fields GELF requires: the version,
+// the host and a short message that is not blank.
+func (log *GELFLogEntry) Validate() error {
+	if log.Version != Version {
+		return fmt.Errorf("unsupported GELF version %q", log.Version)
+	}
+	if strings.TrimSpace(log.Host) == "" {
+		return fmt.Errorf("the host is empty")
+	}
+	if strings.TrimSpace(log.ShortMessage) == "" {
+		return fmt.Errorf("the short message is empty")
+	}
+	return nil
+}
+
+// Encode validates the entry and returns its JSON payload.
+func Encode(log GELFLogEntry) ([]byte, error) {
+	if err := log.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(log)
+}
+
+// Timestamp returns the GELF timestamp of a time, a number of seconds since
+// the epoch with a microsecond precision.
+func Timestamp(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond()/1e3)/1e6
 }
 
 func (log *GELFLogEntry) String() (output string) {
@@ -0,0 +1,103 @@
+package gelf
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalAdditionalFields(t *testing.T) {
+	entry := GELFLogEntry{
+		Version:      "1.1",
+		Host:         "example.org",
+		ShortMessage: "hello",
+		AdditionalFields: map[string]interface{}{
+			"user":       "alice",
+			"_count":     3,
+			"bad name!":  true,
+			"_id":        "forbidden",
+			"_MachineID": "override",
+		},
+	}
+
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatalf("invalid JSON %s: %s", payload, err)
+	}
+
+	if fields["short_message"] != "hello" || fields["_user"] != "alice" || fields["_count"] != 3.0 || fields["_bad_name_"] != true {
+		t.Errorf("unexpected payload %s", payload)
+	}
+	if _, ok := fields["_id"]; ok {
+		t.Error("the _id field is forbidden by GELF")
+	}
+	if _, ok := fields["_MachineID"]; ok {
+		t.Error("an additional field should not override a standard one, even an empty one")
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	if name := FieldName("com.docker/compose service"); name != "_com.docker_compose_service" {
+		t.Errorf("unexpected name %q", name)
+	}
+}
+
+func TestMarshalNumericFields(t *testing.T) {
+	entry := GELFLogEntry{
+		Version:      "1.1",
+		Host:         "example.org",
+		ShortMessage: "hello",
+		PID:          ParseInt("1234"),
+		UID:          ParseInt("0"),
+	}
+	output, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(output, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["_PID"] != 1234.0 || decoded["_UID"] != 0.0 {
+		t.Errorf("got _PID %#v and _UID %#v, expected numbers", decoded["_PID"], decoded["_UID"])
+	}
+	for _, name := range []string{"_GID", "line", "file"} {
+		if _, ok := decoded[name]; ok {
+			t.Errorf("%s should be left out, got %s", name, output)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := GELFLogEntry{Version: Version, Host: "example.org", ShortMessage: "hello"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	tests := map[string]func(e *GELFLogEntry){
+		"version":       func(e *GELFLogEntry) { e.Version = "1.0" },
+		"host":          func(e *GELFLogEntry) { e.Host = "" },
+		"short message": func(e *GELFLogEntry) { e.ShortMessage = " \n" },
+	}
+	for name, invalidate := range tests {
+		entry := valid
+		invalidate(&entry)
+		if _, err := Encode(entry); err == nil {
+			t.Errorf("an entry with an invalid %s should not be encoded", name)
+		}
+	}
+}
+
+func TestMarshalOmitsEmptyFields(t *testing.T) {
+	output, err := Encode(GELFLogEntry{Version: Version, Host: "example.org", ShortMessage: "hello", Level: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":"1.1","host":"example.org","short_message":"hello","timestamp":0,"level":0}`
+	if string(output) != expected {
+		t.Errorf("got %s, expected %s", output, expected)
+	}
+}
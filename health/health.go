@@ -0,0 +1,111 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status tracks the activity of the processing loop, to tell whether
+// journald2graylog is alive and ready to forward log entries.
+type Status struct {
+	// InputTimeout is how long the input can stay silent before the process
+	// is considered stalled, zero disables the check.
+	InputTimeout time.Duration
+	// StallTimeout is how long sending can stay without progress, while
+	// messages are waiting, before the process is considered stalled.
+	StallTimeout time.Duration
+	// QueueThreshold is the queue depth above which the process is not
+	// ready.
+	QueueThreshold int
+	// QueueDepth returns the number of messages waiting to be sent.
+	QueueDepth func() int
+	// Reachable returns true if at least one destination is reachable.
+	Reachable func() bool
+
+	mutex        sync.Mutex
+	lastRead     time.Time
+	lastProgress time.Time
+	sending      bool
+}
+
+// Start records the start of the processing loop.
+func (s *Status) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastRead = time.Now()
+	s.lastProgress = s.lastRead
+}
+
+// Read records that a line was read from the input.
+func (s *Status) Read() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastRead = time.Now()
+}
+
+// Sending records that a message was taken from the queue to be sent.
+func (s *Status) Sending() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sending = true
+	s.lastProgress = time.Now()
+}
+
+// Sent records that a message was sent, or failed to be sent.
+func (s *Status) Sent() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sending = false
+	s.lastProgress = time.Now()
+}
+
+// Live returns an error if the processing loop looks wedged.
+func (s *Status) Live() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if s.InputTimeout > 0 && now.Sub(s.lastRead) > s.InputTimeout {
+		return fmt.Errorf("nothing was read from the input since %s", s.lastRead.Format(time.RFC3339))
+	}
+	if s.StallTimeout > 0 && (s.sending || s.QueueDepth() > 0) && now.Sub(s.lastProgress) > s.StallTimeout {
+		return fmt.Errorf("sending made no progress since %s", s.lastProgress.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Ready returns an error if journald2graylog cannot currently forward log
+// entries.
+func (s *Status) Ready() error {
+	if depth := s.QueueDepth(); depth > s.QueueThreshold {
+		return fmt.Errorf("%d messages are waiting to be sent", depth)
+	}
+	if !s.Reachable() {
+		return fmt.Errorf("no destination is reachable")
+	}
+	return nil
+}
+
+// LiveHandler returns the HTTP handler of the liveness probe.
+func (s *Status) LiveHandler() http.Handler {
+	return probeHandler(s.Live)
+}
+
+// ReadyHandler returns the HTTP handler of the readiness probe.
+func (s *Status) ReadyHandler() http.Handler {
+	return probeHandler(s.Ready)
+}
+
+func probeHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err := check()
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
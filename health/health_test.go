@@ -0,0 +1,75 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestStatus(depth *int, reachable *bool) *Status {
+	s := &Status{
+		InputTimeout:   time.Hour,
+		StallTimeout:   time.Hour,
+		QueueThreshold: 10,
+		QueueDepth:     func() int { return *depth },
+		Reachable:      func() bool { return *reachable },
+	}
+	s.Start()
+	return s
+}
+
+func TestLive(t *testing.T) {
+	depth, reachable := 0, true
+	s := newTestStatus(&depth, &reachable)
+
+	if err := s.Live(); err != nil {
+		t.Error(err)
+	}
+
+	s.lastRead = time.Now().Add(-2 * time.Hour)
+	if err := s.Live(); err == nil {
+		t.Error("a silent input should be reported")
+	}
+	s.Read()
+
+	s.Sending()
+	s.lastProgress = time.Now().Add(-2 * time.Hour)
+	if err := s.Live(); err == nil {
+		t.Error("a send without progress should be reported")
+	}
+	s.Sent()
+
+	// An idle sender is not stalled, as long as nothing is waiting.
+	s.lastProgress = time.Now().Add(-2 * time.Hour)
+	if err := s.Live(); err != nil {
+		t.Error(err)
+	}
+	depth = 1
+	if err := s.Live(); err == nil {
+		t.Error("waiting messages without progress should be reported")
+	}
+}
+
+func TestReady(t *testing.T) {
+	depth, reachable := 0, true
+	s := newTestStatus(&depth, &reachable)
+
+	recorder := httptest.NewRecorder()
+	s.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("unexpected status %d", recorder.Code)
+	}
+
+	depth = 11
+	recorder = httptest.NewRecorder()
+	s.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("a full queue should not be ready, got status %d", recorder.Code)
+	}
+
+	depth, reachable = 0, false
+	if err := s.Ready(); err == nil {
+		t.Error("unreachable destinations should not be ready")
+	}
+}
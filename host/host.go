@@ -0,0 +1,137 @@
+// Package host determines the host the log entries are sent for.
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Unknown is the host of the entries when no hostname can be found.
+const Unknown = "Unknown Host"
+
+// The functions and files used to find the identity of the local host,
+// replaced by the tests.
+var (
+	osHostname    = os.Hostname
+	lookupHost    = net.LookupHost
+	lookupAddr    = net.LookupAddr
+	machineIDFile = "/etc/machine-id"
+)
+
+// Resolver returns the host of the entries, according to the host identity
+// strategy.
+type Resolver struct {
+	strategy string
+	// local is the identity of the local host, the host of all the entries
+	// unless the strategy takes it from the entries.
+	local string
+}
+
+// New returns a resolver for the host configuration. The override, if set,
+// is the host of all the entries.
+func New(c config.Host) (*Resolver, error) {
+	if c.Override != "" {
+		return NewResolver("", c.Override), nil
+	}
+	var local string
+	var err error
+	switch c.Strategy {
+	case config.HostFQDN:
+		local, err = fqdn()
+	case config.HostMachineID:
+		local, err = readFile(machineIDFile)
+	case config.HostFile:
+		local, err = readFile(c.File)
+	default:
+		local, err = hostname()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(c.Strategy, local), nil
+}
+
+// NewResolver returns a resolver for a strategy, given the identity of the
+// local host.
+func NewResolver(strategy string, local string) *Resolver {
+	return &Resolver{strategy: strategy, local: local}
+}
+
+// Default returns the host of the messages that are not journal entries,
+// such as our own log records.
+func (r *Resolver) Default() string {
+	return r.local
+}
+
+// Host returns the host of a journal entry.
+func (r *Resolver) Host(e *journald.JournaldJSONLogEntry) string {
+	switch r.strategy {
+	case config.HostJournal:
+		if e.Hostname != "" && e.Hostname != "localhost" {
+			return e.Hostname
+		}
+	case config.HostMachineID:
+		if e.MachineID != "" {
+			return e.MachineID
+		}
+	}
+	return r.local
+}
+
+// hostname returns the hostname of the local host, or Unknown if it is not
+// set.
+func hostname() (string, error) {
+	name, err := osHostname()
+	if err != nil {
+		return "", fmt.Errorf("could not determine the hostname: %s", err)
+	}
+	if name == "" || name == "localhost" {
+		return Unknown, nil
+	}
+	return name, nil
+}
+
+// fqdn returns the fully qualified domain name of the local host, the name
+// its addresses resolve back to, or its hostname if they do not.
+func fqdn() (string, error) {
+	name, err := hostname()
+	if err != nil || name == Unknown {
+		return name, err
+	}
+	addrs, err := lookupHost(name)
+	if err != nil {
+		return name, nil
+	}
+	for _, addr := range addrs {
+		names, err := lookupAddr(addr)
+		if err != nil {
+			continue
+		}
+		for _, n := range names {
+			n = strings.TrimSuffix(n, ".")
+			if strings.Contains(n, ".") && n != "localhost.localdomain" {
+				return n, nil
+			}
+		}
+	}
+	return name, nil
+}
+
+// readFile returns the first line of a file, such as /etc/hostname.
+func readFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read the host identity: %s", err)
+	}
+	line := strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+	if line == "" {
+		return "", fmt.Errorf("the host identity file %s is empty", path)
+	}
+	return line, nil
+}
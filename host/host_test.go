@@ -0,0 +1,147 @@
+package host
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// fake replaces the identity of the local host, it returns a function
+// restoring it.
+func fake(name string, hosts map[string][]string, addrs map[string][]string) func() {
+	savedHostname, savedLookupHost, savedLookupAddr := osHostname, lookupHost, lookupAddr
+	osHostname = func() (string, error) { return name, nil }
+	lookupHost = func(host string) ([]string, error) {
+		if a, ok := hosts[host]; ok {
+			return a, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	lookupAddr = func(addr string) ([]string, error) {
+		if n, ok := addrs[addr]; ok {
+			return n, nil
+		}
+		return nil, errors.New("no such address")
+	}
+	return func() {
+		osHostname, lookupHost, lookupAddr = savedHostname, savedLookupHost, savedLookupAddr
+	}
+}
+
+func TestJournalStrategy(t *testing.T) {
+	defer fake("pod-1234", nil, nil)()
+	r, err := New(config.Host{Strategy: config.HostJournal})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"node-1":    "node-1",
+		"":          "pod-1234",
+		"localhost": "pod-1234",
+	}
+	for hostname, expected := range tests {
+		if h := r.Host(&journald.JournaldJSONLogEntry{Hostname: hostname}); h != expected {
+			t.Errorf("got host %q for _HOSTNAME %q, expected %q", h, hostname, expected)
+		}
+	}
+	if r.Default() != "pod-1234" {
+		t.Errorf("unexpected default host %q", r.Default())
+	}
+}
+
+func TestUnknownHost(t *testing.T) {
+	defer fake("localhost", nil, nil)()
+	r, err := New(config.Host{Strategy: config.HostOS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Host(&journald.JournaldJSONLogEntry{Hostname: "node-1"}); h != Unknown {
+		t.Errorf("got host %q, expected %q", h, Unknown)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	defer fake("pod-1234", nil, nil)()
+	r, err := New(config.Host{Strategy: config.HostJournal, Override: "node-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Host(&journald.JournaldJSONLogEntry{Hostname: "node-1"}); h != "node-7" {
+		t.Errorf("got host %q, expected the override", h)
+	}
+}
+
+func TestFQDNStrategy(t *testing.T) {
+	defer fake("web-1",
+		map[string][]string{"web-1": {"127.0.1.1", "10.0.0.5"}},
+		map[string][]string{"127.0.1.1": {"localhost.localdomain."}, "10.0.0.5": {"web-1.example.org."}})()
+	r, err := New(config.Host{Strategy: config.HostFQDN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Host(&journald.JournaldJSONLogEntry{Hostname: "web-1"}); h != "web-1.example.org" {
+		t.Errorf("got host %q, expected web-1.example.org", h)
+	}
+
+	// The hostname is kept if it does not resolve.
+	defer fake("web-2", nil, nil)()
+	r, err = New(config.Host{Strategy: config.HostFQDN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Default() != "web-2" {
+		t.Errorf("got host %q, expected web-2", r.Default())
+	}
+}
+
+func TestFileStrategies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald2graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostnameFile := filepath.Join(dir, "hostname")
+	if err := ioutil.WriteFile(hostnameFile, []byte("node-3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(config.Host{Strategy: config.HostFile, File: hostnameFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Host(&journald.JournaldJSONLogEntry{Hostname: "pod-1234"}); h != "node-3" {
+		t.Errorf("got host %q, expected node-3", h)
+	}
+
+	saved := machineIDFile
+	defer func() { machineIDFile = saved }()
+	machineIDFile = filepath.Join(dir, "machine-id")
+	if err := ioutil.WriteFile(machineIDFile, []byte("0123456789abcdef\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err = New(config.Host{Strategy: config.HostMachineID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Host(&journald.JournaldJSONLogEntry{MachineID: "fedcba9876543210"}); h != "fedcba9876543210" {
+		t.Errorf("got host %q, expected the machine ID of the entry", h)
+	}
+	if h := r.Host(&journald.JournaldJSONLogEntry{}); h != "0123456789abcdef" {
+		t.Errorf("got host %q, expected the local machine ID", h)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{empty, filepath.Join(dir, "missing")} {
+		if _, err := New(config.Host{Strategy: config.HostFile, File: path}); err == nil {
+			t.Errorf("reading the host from %s should fail", path)
+		}
+	}
+}
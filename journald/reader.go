@@ -0,0 +1,206 @@
+package journald
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf8"
+)
+
+// Reader reads the newline separated JSON log entries produced by
+// `journalctl -o json`, whatever their length.
+type Reader struct {
+	reader       *bufio.Reader
+	maxEntrySize int
+	keepOversize bool
+	line         []byte
+	spare        []byte
+	shortener    shortener
+}
+
+// NewReader returns a reader of the entries of r. Entries bigger than
+// maxEntrySize bytes are reported as oversize, they are returned with their
+// biggest values shortened if keepOversize is true and consumed but not
+// returned otherwise.
+func NewReader(r io.Reader, maxEntrySize int, keepOversize bool) *Reader {
+	return &Reader{
+		reader:       bufio.NewReader(r),
+		maxEntrySize: maxEntrySize,
+		keepOversize: keepOversize,
+	}
+}
+
+// ReadLine returns the next entry, without its trailing newline. The
+// returned line is only valid until the next call. An oversize entry that is
+// not kept is returned as a nil line, with oversize set to true.
+func (r *Reader) ReadLine() (line []byte, oversize bool, err error) {
+	r.line = r.line[:0]
+	size := 0
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		// The line ending does not count in the size of the entry.
+		n := len(chunk)
+		if n > 0 && chunk[n-1] == '\n' {
+			n--
+			if n > 0 && chunk[n-1] == '\r' {
+				n--
+			}
+		}
+		switch {
+		case size+n <= r.maxEntrySize:
+			r.line = append(r.line, chunk...)
+		case !r.keepOversize:
+			// The rest of an oversize entry that will not be kept is only
+			// consumed.
+		case size <= r.maxEntrySize:
+			// The entry has just become oversize, what was read so far is
+			// shortened like the rest of it. The values are kept up to six
+			// times the maximum entry size, the most that escaping can
+			// expand a string to, so that a shortened MESSAGE is still
+			// truncated, and flagged as such, by TruncateMessage.
+			r.shortener = shortener{max: 6*r.maxEntrySize + 7}
+			r.spare = r.shortener.append(r.spare[:0], r.line)
+			r.spare = r.shortener.append(r.spare, chunk)
+			r.line, r.spare = r.spare, r.line
+		default:
+			r.line = r.shortener.append(r.line, chunk)
+		}
+		size += len(chunk)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && size > 0 {
+			// The last entry is not terminated by a newline.
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		break
+	}
+
+	line = r.line
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+		size--
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+		size--
+	}
+	if size > r.maxEntrySize {
+		if !r.keepOversize {
+			return nil, true, nil
+		}
+		return line, true, nil
+	}
+	return line, false, nil
+}
+
+// shortener copies a JSON object, discarding the end of its values bigger
+// than max bytes: the end of their strings, and the last elements of their
+// arrays and objects, so that the object stays valid while an oversize entry
+// is never buffered whole. The strings are cut between two characters.
+type shortener struct {
+	max      int
+	depth    int
+	inString bool
+	// escape is -1 after a backslash, and the number of hexadecimal digits
+	// left in a \u escape sequence.
+	escape int
+	// key is set when the next string of the object is a field name.
+	key bool
+	// size is the number of bytes of the current field value, and length
+	// that of the current string.
+	size   int
+	length int
+	skip   int
+}
+
+// The parts of a value being discarded.
+const (
+	skipNone = iota
+	skipString
+	skipElements
+)
+
+// append appends the next chunk of the object to dst, without the bytes
+// discarded.
+func (s *shortener) append(dst, chunk []byte) []byte {
+	start := 0
+	for i, c := range chunk {
+		if s.inString {
+			if s.skip == skipNone && !s.key && s.length >= s.max && s.escape == 0 && c != '"' && utf8.RuneStart(c) {
+				dst = append(dst, chunk[start:i]...)
+				s.skip = skipString
+			}
+			switch {
+			case s.escape == -1 && c == 'u':
+				s.escape = 4
+			case s.escape == -1:
+				s.escape = 0
+			case s.escape > 0:
+				s.escape--
+			case c == '\\':
+				s.escape = -1
+			case c == '"':
+				s.inString = false
+				if s.skip == skipString {
+					s.skip = skipNone
+					start = i
+				}
+			}
+		} else {
+			switch c {
+			case '"':
+				s.inString = true
+				s.length = 0
+			case '{', '[':
+				s.depth++
+				if s.depth == 1 {
+					s.key = true
+				}
+			case '}', ']':
+				s.depth--
+				if s.skip == skipElements && s.depth == 1 {
+					s.skip = skipNone
+					start = i
+				}
+			case ':':
+				if s.depth == 1 {
+					s.key = false
+					s.size = 0
+				}
+			case ',':
+				if s.depth == 1 {
+					s.key = true
+				} else if s.depth == 2 && s.skip == skipNone && s.size >= s.max {
+					dst = append(dst, chunk[start:i]...)
+					s.skip = skipElements
+				}
+			}
+		}
+		if s.skip == skipNone && !s.key {
+			s.size++
+			s.length++
+		}
+	}
+	if s.skip == skipNone {
+		dst = append(dst, chunk[start:]...)
+	}
+	return dst
+}
+
+// TruncateMessage shortens the MESSAGE of the entry to at most max bytes,
+// without splitting a UTF-8 encoded character. It returns true if the
+// message was truncated.
+func (e *JournaldJSONLogEntry) TruncateMessage(max int) bool {
+	if max <= 0 || len(e.Message) <= max {
+		return false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(e.Message[cut]) {
+		cut--
+	}
+	e.Message = e.Message[:cut]
+	return true
+}
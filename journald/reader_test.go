@@ -0,0 +1,104 @@
+package journald
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderLongLines(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	r := NewReader(strings.NewReader("short\r\n"+long+"\n"+"last"), 20000, false)
+
+	expected := []string{"short", long, "last"}
+	for _, e := range expected {
+		line, oversize, err := r.ReadLine()
+		if err != nil || oversize || string(line) != e {
+			t.Fatalf("unexpected line of %d bytes (oversize:%t err:%v)", len(line), oversize, err)
+		}
+	}
+	if _, _, err := r.ReadLine(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReaderDropsOversize(t *testing.T) {
+	r := NewReader(strings.NewReader(strings.Repeat("x", 10000)+"\nnext\n"), 100, false)
+
+	line, oversize, err := r.ReadLine()
+	if err != nil || !oversize || line != nil {
+		t.Fatalf("the oversize line should be dropped, got %d bytes (oversize:%t err:%v)", len(line), oversize, err)
+	}
+
+	// The remaining fragments of the oversize line must not be read as
+	// separate lines.
+	line, oversize, err = r.ReadLine()
+	if err != nil || oversize || string(line) != "next" {
+		t.Errorf("unexpected line %q (oversize:%t err:%v)", line, oversize, err)
+	}
+}
+
+func TestReaderBoundary(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		r := NewReader(strings.NewReader("0123456789\n0123456789\r\n0123456789a\n"), 10, keep)
+		for _, e := range []string{"0123456789", "0123456789"} {
+			line, oversize, err := r.ReadLine()
+			if err != nil || oversize || string(line) != e {
+				t.Errorf("an entry of the maximum size should be read, got %q (oversize:%t err:%v)", line, oversize, err)
+			}
+		}
+		if _, oversize, err := r.ReadLine(); err != nil || !oversize {
+			t.Errorf("an entry bigger than the maximum size should be oversize (err:%v)", err)
+		}
+	}
+}
+
+func TestReaderKeepsOversize(t *testing.T) {
+	long := strings.Repeat("é", 5000)
+	escaped := strings.Repeat(`\u00e9`, 5000)
+	input := `{"MESSAGE":"` + long + `","CODE_FILE":"` + escaped + `","IDS":[` + strings.Repeat(`"1",`, 5000) + `"2"],"PRIORITY":"6"}` + "\n"
+	r := NewReader(strings.NewReader(input), 100, true)
+
+	line, oversize, err := r.ReadLine()
+	if err != nil || !oversize {
+		t.Fatalf("the oversize line should be kept, got %d bytes (oversize:%t err:%v)", len(line), oversize, err)
+	}
+	// Its values are shortened while it is read, but remain valid and
+	// longer than the maximum entry size.
+	if len(line) > 4*607 {
+		t.Errorf("the oversize line should be shortened, got %d bytes", len(line))
+	}
+	var entry struct {
+		Message  string   `json:"MESSAGE"`
+		CodeFile string   `json:"CODE_FILE"`
+		IDs      []string `json:"IDS"`
+		Priority string   `json:"PRIORITY"`
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		t.Fatalf("the shortened line should be valid, got %v", err)
+	}
+	if len(entry.Message) <= 100 || !strings.HasPrefix(long, entry.Message) {
+		t.Errorf("unexpected message of %d bytes", len(entry.Message))
+	}
+	if len(entry.CodeFile) <= 100 || !strings.HasPrefix(long, entry.CodeFile) {
+		t.Errorf("unexpected escaped field of %d bytes", len(entry.CodeFile))
+	}
+	if len(entry.IDs) < 2 || len(entry.IDs) > 200 || entry.IDs[len(entry.IDs)-1] != "1" {
+		t.Errorf("unexpected array of %d elements", len(entry.IDs))
+	}
+	if entry.Priority != "6" {
+		t.Errorf("the fields after the oversize values should be kept, got %q", entry.Priority)
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	entry := JournaldJSONLogEntry{Message: "héllo"}
+
+	if entry.TruncateMessage(10) {
+		t.Error("a short message should not be truncated")
+	}
+	if !entry.TruncateMessage(2) || entry.Message != "h" {
+		t.Errorf("the message should be truncated before the multibyte character, got %q", entry.Message)
+	}
+}
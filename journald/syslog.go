@@ -0,0 +1,51 @@
+package journald
+
+import "strconv"
+
+// facilities are the names of the syslog facilities, as listed by RFC 5424
+// and named by the usual syslog implementations.
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// priorities are the names of the syslog severities, as listed by RFC 5424.
+var priorities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// PriorityInfo is the informational severity, the priority journald gives
+// to the entries by default.
+const PriorityInfo = 6
+
+// FacilityName returns the name of a numeric SYSLOG_FACILITY, or the value
+// itself if it is not a known facility number.
+func FacilityName(facility string) string {
+	n, err := strconv.Atoi(facility)
+	if err != nil || n < 0 || n >= len(facilities) {
+		return facility
+	}
+	return facilities[n]
+}
+
+// PriorityName returns the name of a syslog severity, or an empty string if
+// it is not a known severity.
+func PriorityName(priority int) string {
+	if priority < 0 || priority >= len(priorities) {
+		return ""
+	}
+	return priorities[priority]
+}
+
+// Identifier returns the syslog identifier of the entry or, for the entries
+// of the Docker containers without one, their tag or their name.
+func (e *JournaldJSONLogEntry) Identifier() string {
+	switch {
+	case e.SyslogIdentifier != "":
+		return e.SyslogIdentifier
+	case e.ContainerTag != "":
+		return e.ContainerTag
+	}
+	return e.ContainerName
+}
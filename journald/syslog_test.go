@@ -0,0 +1,31 @@
+package journald
+
+import "testing"
+
+func TestFacilityName(t *testing.T) {
+	tests := map[string]string{
+		"0":      "kern",
+		"3":      "daemon",
+		"10":     "authpriv",
+		"16":     "local0",
+		"23":     "local7",
+		"24":     "24",
+		"-1":     "-1",
+		"daemon": "daemon",
+		"":       "",
+	}
+	for facility, expected := range tests {
+		if name := FacilityName(facility); name != expected {
+			t.Errorf("%q: expected %q, got %q", facility, expected, name)
+		}
+	}
+}
+
+func TestPriorityName(t *testing.T) {
+	tests := map[int]string{0: "emerg", 3: "err", 4: "warning", 7: "debug", 8: "", -1: ""}
+	for priority, expected := range tests {
+		if name := PriorityName(priority); name != expected {
+			t.Errorf("%d: expected %q, got %q", priority, expected, name)
+		}
+	}
+}
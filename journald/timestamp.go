@@ -0,0 +1,56 @@
+package journald
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Timestamp sources, the timestamps an entry can be dated with.
+const (
+	// TimestampMessage is the timestamp found in the message, by the JSON
+	// and logfmt parsers.
+	TimestampMessage = "message"
+	// TimestampSource is _SOURCE_REALTIME_TIMESTAMP, the time the entry was
+	// logged at by its client, when known.
+	TimestampSource = "source"
+	// TimestampRealtime is __REALTIME_TIMESTAMP, the time the entry was
+	// received by journald.
+	TimestampRealtime = "realtime"
+)
+
+// minTimestamp is the earliest valid timestamp, the earlier ones, such as
+// those of the hosts without a real-time clock, being absurd.
+var minTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ParseMicroseconds parses a journald timestamp, a number of microseconds
+// since the epoch.
+func ParseMicroseconds(value string) (time.Time, error) {
+	us, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	return time.Unix(us/1e6, us%1e6*1e3), nil
+}
+
+// Time returns the time the entry was logged at, from the first of the
+// sources that holds a valid timestamp. A timestamp is valid if it is not
+// before 2000 and not more than maxFuture after now. It returns false if none
+// of the sources holds a valid timestamp.
+func (r *Record) Time(sources []string, now time.Time, maxFuture time.Duration) (time.Time, bool) {
+	for _, source := range sources {
+		var t time.Time
+		switch source {
+		case TimestampMessage:
+			t = r.Timestamp
+		case TimestampSource:
+			t, _ = ParseMicroseconds(r.Entry.SourceRealtimeTimestamp)
+		case TimestampRealtime:
+			t, _ = ParseMicroseconds(r.Entry.RealtimeTimestamp)
+		}
+		if !t.Before(minTimestamp) && !t.After(now.Add(maxFuture)) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,72 @@
+package journald
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMicroseconds(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Time
+		valid    bool
+	}{
+		{"1521040166535123", time.Unix(1521040166, 535123000), true},
+		{"1521040166000000", time.Unix(1521040166, 0), true},
+		{"0", time.Unix(0, 0), true},
+		{"-1500000", time.Unix(-1, -500000000), true},
+		{"", time.Time{}, false},
+		{"1521040166.535123", time.Time{}, false},
+		{"99999999999999999999", time.Time{}, false},
+	}
+	for _, test := range tests {
+		ts, err := ParseMicroseconds(test.value)
+		if (err == nil) != test.valid || !ts.Equal(test.expected) {
+			t.Errorf("%q: expected %s %t, got %s %v", test.value, test.expected, test.valid, ts, err)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	now := time.Date(2018, 3, 14, 16, 0, 0, 0, time.UTC)
+	sources := []string{TimestampMessage, TimestampSource, TimestampRealtime}
+	message := time.Date(2018, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		record    Record
+		sources   []string
+		expected  time.Time
+		available bool
+	}{
+		{"message first", Record{Timestamp: message, Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "1521040166535123", RealtimeTimestamp: "1521040166600000"}},
+			sources, message, true},
+		{"source before realtime", Record{Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "1521040166535123", RealtimeTimestamp: "1521040166600000"}},
+			sources, time.Unix(1521040166, 535123000), true},
+		{"realtime only", Record{Entry: JournaldJSONLogEntry{RealtimeTimestamp: "1521040166600000"}},
+			sources, time.Unix(1521040166, 600000000), true},
+		{"preference", Record{Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "1521040166535123", RealtimeTimestamp: "1521040166600000"}},
+			[]string{TimestampRealtime, TimestampSource}, time.Unix(1521040166, 600000000), true},
+		{"invalid source", Record{Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "soon", RealtimeTimestamp: "1521040166600000"}},
+			sources, time.Unix(1521040166, 600000000), true},
+		{"pre-epoch source", Record{Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "-1000000", RealtimeTimestamp: "1521040166600000"}},
+			sources, time.Unix(1521040166, 600000000), true},
+		{"epoch", Record{Entry: JournaldJSONLogEntry{RealtimeTimestamp: "0"}},
+			sources, time.Time{}, false},
+		{"before 2000", Record{Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "123", RealtimeTimestamp: "946684799999999"}},
+			sources, time.Time{}, false},
+		{"2000", Record{Entry: JournaldJSONLogEntry{RealtimeTimestamp: "946684800000000"}},
+			sources, time.Unix(946684800, 0), true},
+		{"far future", Record{Entry: JournaldJSONLogEntry{SourceRealtimeTimestamp: "4102444800000000", RealtimeTimestamp: "1521040166600000"}},
+			sources, time.Unix(1521040166, 600000000), true},
+		{"near future", Record{Entry: JournaldJSONLogEntry{RealtimeTimestamp: "1521046800000000"}},
+			sources, time.Unix(1521046800, 0), true},
+		{"missing", Record{}, sources, time.Time{}, false},
+	}
+	for _, test := range tests {
+		ts, ok := test.record.Time(test.sources, now, time.Hour)
+		if ok != test.available || !ts.Equal(test.expected) {
+			t.Errorf("%s: expected %s %t, got %s %t", test.name, test.expected, test.available, ts, ok)
+		}
+	}
+}
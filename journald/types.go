@@ -1,5 +1,11 @@
 package journald
 
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
 // JournaldJSONLogEntry is the structure that maps all the major journald log
 // entry fields.
 type JournaldJSONLogEntry struct {
@@ -9,7 +15,7 @@ type JournaldJSONLogEntry struct {
 	Priority         string `json:"PRIORITY"`
 	CodeFile         string `json:"CODE_FILE,omitempty"`
 	CodeLine         string `json:"CODE_LINE,omitempty"`
-	CodeFunction     string `json:"CODE_FUNCTION,omitempty"`
+	CodeFunction     string `json:"CODE_FUNC,omitempty"`
 	Errno            string `json:"ERRNO"`
 	SyslogFacility   string `json:"SYSLOG_FACILITY,omitempty"`
 	SyslogIdentifier string `json:"SYSLOG_IDENTIFIER,omitempty"`
@@ -43,8 +49,63 @@ type JournaldJSONLogEntry struct {
 	// Transport is one of 'audit' 'driver' 'syslog' 'journal' 'stdout' 'kernel'
 	Transport string `json:"_TRANSPORT"`
 
+	// Docker Fields (from the journald logging driver docs)
+	ContainerID             string `json:"CONTAINER_ID,omitempty"`
+	ContainerIDFull         string `json:"CONTAINER_ID_FULL,omitempty"`
+	ContainerName           string `json:"CONTAINER_NAME,omitempty"`
+	ContainerTag            string `json:"CONTAINER_TAG,omitempty"`
+	ContainerPartialMessage string `json:"CONTAINER_PARTIAL_MESSAGE,omitempty"`
+	ImageName               string `json:"IMAGE_NAME,omitempty"`
+
 	// Address Fields (from freedesktop docs)
 	Cursor             string `json:"__CURSOR"`
 	RealtimeTimestamp  string `json:"__REALTIME_TIMESTAMP"`
 	MonotonicTimestamp string `json:"__MONOTONIC_TIMESTAMP"`
 }
+
+// fieldIndexes are the indexes of the fields of JournaldJSONLogEntry, by
+// journal field name.
+var fieldIndexes = func() map[string]int {
+	indexes := map[string]int{}
+	t := reflect.TypeOf(JournaldJSONLogEntry{})
+	for i := 0; i < t.NumField(); i++ {
+		indexes[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = i
+	}
+	return indexes
+}()
+
+// Field returns the value of a journal field of the entry, by name, and
+// false if the entry does not hold the field.
+func (e *JournaldJSONLogEntry) Field(name string) (string, bool) {
+	i, ok := fieldIndexes[name]
+	if !ok {
+		return "", false
+	}
+	return reflect.ValueOf(e).Elem().Field(i).String(), true
+}
+
+// Record is a parsed log entry, along with the raw journald JSON log line it
+// was parsed from.
+type Record struct {
+	Entry JournaldJSONLogEntry
+	Line  []byte
+	// FullMessage is set when the message of the entry is only the first
+	// line of a longer text, as when several entries are joined together.
+	FullMessage string
+	// Truncated is set when the message of the entry was truncated.
+	Truncated bool
+	// Timestamp, when set, is the time the entry was logged at according to
+	// its message, see TimestampMessage.
+	Timestamp time.Time
+	// Fields are the additional GELF fields added to the entry while it is
+	// processed.
+	Fields map[string]interface{}
+}
+
+// SetField adds an additional GELF field to the record.
+func (r *Record) SetField(name string, value interface{}) {
+	if r.Fields == nil {
+		r.Fields = map[string]interface{}{}
+	}
+	r.Fields[name] = value
+}
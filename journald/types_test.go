@@ -0,0 +1,21 @@
+package journald
+
+import "testing"
+
+func TestField(t *testing.T) {
+	e := &JournaldJSONLogEntry{SystemdUnit: "ssh.service", CodeLine: "42"}
+	tests := map[string]struct {
+		value string
+		ok    bool
+	}{
+		"_SYSTEMD_UNIT": {"ssh.service", true},
+		"CODE_LINE":     {"42", true},
+		"_PID":          {"", true},
+		"UNKNOWN":       {"", false},
+	}
+	for name, expected := range tests {
+		if value, ok := e.Field(name); value != expected.value || ok != expected.ok {
+			t.Errorf("%s: got %q %v, expected %q %v", name, value, ok, expected.value, expected.ok)
+		}
+	}
+}
@@ -1,158 +1,512 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
-	"strings"
+	"text/template"
+	"time"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/cdemers/journald2graylog/blacklist"
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/container"
 	"github.com/cdemers/journald2graylog/gelf"
+	"github.com/cdemers/journald2graylog/health"
+	"github.com/cdemers/journald2graylog/host"
 	"github.com/cdemers/journald2graylog/journald"
-	rkgelf "github.com/robertkowalski/graylog-golang"
+	"github.com/cdemers/journald2graylog/k8s"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/metrics"
+	"github.com/cdemers/journald2graylog/multiline"
+	"github.com/cdemers/journald2graylog/output"
+	"github.com/cdemers/journald2graylog/parser"
+	"github.com/cdemers/journald2graylog/redact"
 )
 
 var (
-	verbose           = kingpin.Flag("verbose", "Wether journald2graylog will be verbose or not.").Short('v').Bool()
-	enableRawLogLine  = kingpin.Flag("enable-rawlogline", "Wether journald2graylog will send the raw log line or not, disabled by default.").Envar("J2G_ENABLE_RAWLOGLINE").Bool()
-	blacklistFlag     = kingpin.Flag("blacklist", "Prevent sending matching logs to the Graylog server. The value of this parameter can be one or more Regex separated by a semicolon ( e.g. : \"foo.*;bar.*\" )").Envar("J2G_BLACKLIST").String()
-	graylogHostname   = kingpin.Flag("hostname", "Hostname or IP of your Graylog server, it has no default and MUST be specified").Envar("J2G_HOSTNAME").Required().String()
-	graylogPort       = kingpin.Flag("port", "Port of the UDP GELF input of the Graylog server").Default("12201").Envar("J2G_PORT").Int()
-	graylogPacketSize = kingpin.Flag("packet-size", "Maximum size of the TCP/IP packets you can use between the source (journald2graylg) and the destination (your Graylog server)").Default("1420").Envar("J2G_PACKET_SIZE").Int()
+	cfg = config.Default()
+
+	configFile = kingpin.Flag("config", "Path of an optional YAML configuration file, its settings are overridden by environment variables and command line flags.").Envar("J2G_CONFIG").String()
+
+	runCommand        = kingpin.Command("run", "Forward the journald JSON entries read from stdin to the Graylog server.").Default()
+	configCommand     = kingpin.Command("config", "Inspect the journald2graylog configuration.")
+	configDumpCommand = configCommand.Command("dump", "Print the effective configuration, once the configuration file, environment variables and flags are merged.")
 )
 
+func init() {
+	kingpin.Flag("verbose", "Wether journald2graylog will be verbose or not, same as --log-level=debug.").Short('v').BoolVar(&cfg.Verbose)
+	kingpin.Flag("log-level", "Level of the internal diagnostics: error, warn, info or debug, defaults to info").Envar("J2G_LOG_LEVEL").StringVar(&cfg.Log.Level)
+	kingpin.Flag("log-format", "Format of the internal diagnostics: text or json, defaults to text").Envar("J2G_LOG_FORMAT").EnumVar(&cfg.Log.Format, logging.FormatText, logging.FormatJSON)
+	kingpin.Flag("debug-payloads", "Log every GELF payload sent, implies --log-level=debug.").Envar("J2G_DEBUG_PAYLOADS").BoolVar(&cfg.Log.DebugPayloads)
+	kingpin.Flag("log-to-graylog", "Ship the internal diagnostics, of level info and above, to the destinations along with the log entries.").Envar("J2G_LOG_TO_GRAYLOG").BoolVar(&cfg.Log.Graylog)
+	kingpin.Flag("enable-rawlogline", "Wether journald2graylog will send the raw log line or not, disabled by default.").Envar("J2G_ENABLE_RAWLOGLINE").BoolVar(&cfg.EnableRawLogLine)
+	kingpin.Flag("blacklist", "Prevent sending matching logs to the Graylog server. The value of this parameter can be one or more Regex separated by a semicolon ( e.g. : \"foo.*;bar.*\" )").Envar("J2G_BLACKLIST").SetValue(config.ListValue{List: &cfg.Blacklist})
+	kingpin.Flag("hostname", "Hostname or IP of your Graylog server, it has no default and MUST be specified").Envar("J2G_HOSTNAME").StringVar(&cfg.Graylog.Hostname)
+	kingpin.Flag("port", "Port of the UDP GELF input of the Graylog server, defaults to 12201").Envar("J2G_PORT").IntVar(&cfg.Graylog.Port)
+	kingpin.Flag("packet-size", "Maximum size of the TCP/IP packets you can use between the source (journald2graylg) and the destination (your Graylog server), defaults to 1420").Envar("J2G_PACKET_SIZE").IntVar(&cfg.Graylog.PacketSize)
+	kingpin.Flag("max-entry-size", "Maximum size, in bytes, of a journald JSON log line, defaults to 1048576").Envar("J2G_MAX_ENTRY_SIZE").IntVar(&cfg.Input.MaxEntrySize)
+	kingpin.Flag("oversize", "What to do with the log lines bigger than the maximum entry size: drop them, or truncate their message and send them with a _truncated field, defaults to drop").Envar("J2G_OVERSIZE").EnumVar(&cfg.Input.Oversize, config.OversizeDrop, config.OversizeTruncate)
+	kingpin.Flag("docker-join-partial", "Join the fragments of the long lines that Docker splits into several entries flagged CONTAINER_PARTIAL_MESSAGE, enabled by default.").Envar("J2G_DOCKER_JOIN_PARTIAL").BoolVar(&cfg.Docker.JoinPartial)
+	kingpin.Flag("docker-enrich", "Add the image and the labels of the Docker containers, queried from the Docker daemon, to their entries, disabled by default.").Envar("J2G_DOCKER_ENRICH").BoolVar(&cfg.Docker.Enrich)
+	kingpin.Flag("docker-socket", "Path of the unix socket of the Docker daemon, defaults to /var/run/docker.sock").Envar("J2G_DOCKER_SOCKET").StringVar(&cfg.Docker.Socket)
+	kingpin.Flag("kubernetes-metadata", "Add the namespace, the pod and the container of the Kubernetes pods, found in their container names and cgroup paths, to their entries, enabled by default.").Envar("J2G_KUBERNETES_METADATA").BoolVar(&cfg.Kubernetes.Metadata)
+	kingpin.Flag("kubernetes-enrich", "Add the labels of the Kubernetes pods, watched through the Kubernetes API, to their entries, disabled by default.").Envar("J2G_KUBERNETES_ENRICH").BoolVar(&cfg.Kubernetes.Enrich)
+	kingpin.Flag("kubernetes-node", "Name of the Kubernetes node whose pods are watched, required by --kubernetes-enrich").Envar("J2G_KUBERNETES_NODE").StringVar(&cfg.Kubernetes.Node)
+	kingpin.Flag("json", "Parse the messages that are JSON objects into GELF additional fields, lifting their message, level and timestamp, disabled by default.").Envar("J2G_JSON").BoolVar(&cfg.JSON.Enabled)
+	kingpin.Flag("json-prefix", "Prefix of the names of the fields parsed from JSON messages").Envar("J2G_JSON_PREFIX").StringVar(&cfg.JSON.Prefix)
+	kingpin.Flag("logfmt", "Parse the key=value pairs of the messages into GELF additional fields, lifting their message, level and timestamp, disabled by default.").Envar("J2G_LOGFMT").BoolVar(&cfg.Logfmt.Enabled)
+	kingpin.Flag("logfmt-units", "Systemd units whose messages are parsed as key=value pairs, separated by a semicolon, all of them by default").Envar("J2G_LOGFMT_UNITS").SetValue(config.ListValue{List: &cfg.Logfmt.Units})
+	kingpin.Flag("logfmt-strip", "Remove the key=value pairs from the messages, when no message is lifted from them.").Envar("J2G_LOGFMT_STRIP").BoolVar(&cfg.Logfmt.Strip)
+	kingpin.Flag("redact", "Redact the credit card numbers, emails, bearer tokens and passwords found in the entries before sending them, disabled by default.").Envar("J2G_REDACT").BoolVar(&cfg.Redact.Enabled)
+	kingpin.Flag("redact-mode", "What the sensitive data is replaced with: replace (with [REDACTED]) or hash (with its HMAC), defaults to replace").Envar("J2G_REDACT_MODE").EnumVar(&cfg.Redact.Mode, config.RedactReplace, config.RedactHash)
+	kingpin.Flag("redact-key-file", "Path of the file holding the HMAC key of the hash redaction mode").Envar("J2G_REDACT_KEY_FILE").StringVar(&cfg.Redact.KeyFile)
+	kingpin.Flag("timestamp-sources", "Timestamps the entries are dated with, in order of preference, separated by a semicolon: message (found in the message by the JSON and logfmt parsers), source (_SOURCE_REALTIME_TIMESTAMP) and realtime (__REALTIME_TIMESTAMP), defaults to \"message;source;realtime\"").Envar("J2G_TIMESTAMP_SOURCES").SetValue(config.ListValue{List: &cfg.Timestamp.Sources})
+	kingpin.Flag("facility-format", "Go template of the GELF facility, executed with .Facility (the name of the syslog facility), .FacilityNumber, .Identifier and .Unit, defaults to \"daemon (sshd)\", \"daemon\", \"sshd\" or \"Undefined\" depending on what is known").Envar("J2G_FACILITY_FORMAT").StringVar(&cfg.GELF.FacilityFormat)
+	kingpin.Flag("empty-messages", "What to do with the entries whose message is empty, as GELF requires one: send them with a placeholder message, or drop them, defaults to placeholder").Envar("J2G_EMPTY_MESSAGES").EnumVar(&cfg.GELF.EmptyMessages, config.EmptyPlaceholder, config.EmptyDrop)
+	kingpin.Flag("host-override", "Host of all the entries, overriding the host identity strategy").Envar("J2G_HOST_OVERRIDE").StringVar(&cfg.Host.Override)
+	kingpin.Flag("host-strategy", "Where the host of the entries is taken from: journal (their _HOSTNAME), os (the local hostname), fqdn (the name the local addresses resolve to), machine-id (their _MACHINE_ID) or file (the first line of --host-file), defaults to journal").Envar("J2G_HOST_STRATEGY").EnumVar(&cfg.Host.Strategy, config.HostJournal, config.HostOS, config.HostFQDN, config.HostMachineID, config.HostFile)
+	kingpin.Flag("host-file", "File holding the host of the entries with the file host strategy, such as the /etc/hostname of the node").Envar("J2G_HOST_FILE").StringVar(&cfg.Host.File)
+	kingpin.Flag("host-original-field", "Field keeping the _HOSTNAME of the entries when it is not their host, none by default").Envar("J2G_HOST_ORIGINAL_FIELD").StringVar(&cfg.Host.OriginalField)
+	kingpin.Flag("multiline", "Join the entries continuing a previous message of the same unit, PID and container, such as the lines of a stack trace, disabled by default.").Envar("J2G_MULTILINE").BoolVar(&cfg.Multiline.Enabled)
+	kingpin.Flag("multiline-start", "Regex matching the first line of a message, the lines that do not match it continue the previous message").Envar("J2G_MULTILINE_START").StringVar(&cfg.Multiline.Start)
+	kingpin.Flag("multiline-continuation", "Regex matching the lines that continue the previous message, defaults to indented lines and the \"Caused by:\" and \"... N more\" lines of Java stack traces").Envar("J2G_MULTILINE_CONTINUATION").StringVar(&cfg.Multiline.Continuation)
+	kingpin.Flag("queue-size", "Number of messages that can wait to be sent before reading stdin is blocked, defaults to 1000").Envar("J2G_QUEUE_SIZE").IntVar(&cfg.QueueSize)
+	kingpin.Flag("http-listen", "Address (e.g. : \":9110\") of the HTTP listener exposing the Prometheus metrics on /metrics and the health probes on /healthz and /readyz, disabled by default").Envar("J2G_HTTP_LISTEN").StringVar(&cfg.HTTPListen)
+	kingpin.Flag("output-mode", "How the log entries are dispatched between the destinations: fanout (to all of them), failover (to the first healthy one) or loadbalance (to the next healthy one), defaults to fanout").Envar("J2G_OUTPUT_MODE").EnumVar(&cfg.Outputs.Mode, config.ModeFanout, config.ModeFailover, config.ModeLoadBalance)
+}
+
 func main() {
-	kingpin.Parse()
+	// The configuration file must be loaded before the flags and environment
+	// variables are parsed, so that they take precedence over it.
+	kingpin.FatalIfError(loadConfigFile(os.Args[1:]), "")
+
+	command := kingpin.Parse()
+
+	if command == configDumpCommand.FullCommand() {
+		dump, err := cfg.Dump()
+		kingpin.FatalIfError(err, "")
+		fmt.Print(dump)
+		return
+	}
+
+	kingpin.FatalIfError(cfg.Validate(), "")
+
+	// The level was validated along with the rest of the configuration.
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	if cfg.Verbose || cfg.Log.DebugPayloads {
+		level = logging.DebugLevel
+	}
+	kingpin.FatalIfError(logging.Configure(level, cfg.Log.Format), "")
+	// Route the messages of the libraries using the standard logger through
+	// our own.
+	log.SetFlags(0)
+	log.SetOutput(logging.Writer(logging.WarnLevel))
 
-	if *verbose {
-		log.Printf("Graylog host:\"%s\" port:\"%d\" packet size:\"%d\" blacklist:\"%v\" enableRawLogLine:\"%t\"",
-			*graylogHostname, *graylogPort, *graylogPacketSize, strings.Split(*blacklistFlag, ";"), *enableRawLogLine)
+	logging.WithFields(logging.Fields{
+		"mode":              cfg.Outputs.Mode,
+		"blacklist":         cfg.Blacklist,
+		"enable_rawlogline": cfg.EnableRawLogLine,
+	}).Debugf("Configuration loaded")
+	for _, d := range cfg.Destinations() {
+		logging.WithFields(logging.Fields{
+			"output":      d.Name,
+			"type":        d.Type,
+			"host":        d.Hostname,
+			"port":        d.Port,
+			"packet_size": d.PacketSize,
+		}).Debugf("Output configured")
 	}
 
-	// Determine what will be the default value of the "hostname" field in the
-	// GELF payload.
-	defaultHostname, err := os.Hostname()
+	// Determine what will be the value of the "host" field in the GELF
+	// payload.
+	hosts, err := host.New(cfg.Host)
 	if err != nil {
-		panic(err)
+		logging.Fatalf("Could not determine the host identity: %s", err)
 	}
-	if defaultHostname == "localhost" || defaultHostname == "" {
-		defaultHostname = "Unknown Host"
+
+	// Build the outputs that will allow us to transmit messages to the
+	// destinations.
+	outputs, err := output.NewGroup(cfg.Outputs.Mode, cfg.Outputs.RetryInterval, cfg.Destinations())
+	if err != nil {
+		logging.Fatalf("Could not build the outputs: %s", err)
 	}
+	// The outputs sending batches send the messages they hold when closed.
+	defer func() {
+		if err := outputs.Close(); err != nil {
+			logging.WithFields(logging.Fields{"error": err}).Errorf("Could not close the outputs")
+		}
+	}()
 
-	// Build the object that will allow us to transmit messages to the Graylog
-	// server.
-	graylog := rkgelf.New(rkgelf.Config{
-		GraylogHostname: *graylogHostname,
-		GraylogPort:     *graylogPort,
-		Connection:      "wan",
-		MaxChunkSizeLan: *graylogPacketSize,
+	// The messages are sent from a separate goroutine, so that a slow
+	// destination does not prevent us from reading stdin, as long as the
+	// queue is not full.
+	queue := make(chan *output.Message, cfg.QueueSize)
+	metrics.NewGaugeFunc("journald2graylog_queue_depth", "Number of messages waiting to be sent.", func() float64 {
+		return float64(len(queue))
 	})
 
-	b := blacklist.PrepareBlacklist(blacklistFlag)
+	status := &health.Status{
+		InputTimeout:   cfg.Health.InputTimeout,
+		StallTimeout:   cfg.Health.StallTimeout,
+		QueueThreshold: int(float64(cfg.QueueSize) * cfg.Health.ReadyQueueRatio),
+		QueueDepth:     func() int { return len(queue) },
+		Reachable:      outputs.Healthy,
+	}
+	status.Start()
 
-	// Build the go reader of stdin from where the log stream will be coming from.
-	reader := bufio.NewReader(os.Stdin)
+	var redactor *redact.Redactor
+	if cfg.Redact.Enabled {
+		redactor, err = redact.New(cfg.Redact, cfg.EnableRawLogLine)
+		if err != nil {
+			logging.Fatalf("Could not build the redaction stage: %s", err)
+		}
+	}
 
-	// Loop and process entries from stdin until EOF.
-	for {
-		line, overflow, err := reader.ReadLine()
-		if err == io.EOF {
-			os.Exit(0)
+	// Ship our own diagnostics along with the log entries, when requested.
+	// They are redacted too, as they can quote the entries.
+	if cfg.Log.Graylog {
+		logging.SetHook(func(r logging.Record) {
+			msg := diagnosticMessage(r, hosts.Default(), cfg.Log.Facility, redactor)
+			if msg == nil {
+				return
+			}
+			// Never block, a diagnostic is not worth stalling the log
+			// entries.
+			select {
+			case queue <- msg:
+			default:
+			}
+		})
+	}
+
+	if cfg.HTTPListen != "" {
+		go serveHTTP(cfg.HTTPListen, status)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range queue {
+			status.Sending()
+			err := outputs.Send(msg)
+			status.Sent()
+			// A destination that cannot be reached is not a reason to
+			// stop forwarding to the others: the failures are counted by
+			// output, and the group tries the failed outputs again once
+			// their retry interval elapsed. The diagnostics that could
+			// not be sent are only logged at the debug level, which is not
+			// shipped, lest each failure be shipped to the failing
+			// destination again.
+			if err != nil && msg.Diagnostic {
+				logging.WithFields(logging.Fields{"error": err}).Debugf("Could not send a diagnostic")
+			} else if err != nil {
+				logging.WithFields(logging.Fields{"error": err}).Errorf("Could not send a message")
+			}
 		}
+	}()
+
+	p := &processor{
+		blacklist:        blacklist.FromList(cfg.Blacklist),
+		queue:            queue,
+		enableRawLogLine: cfg.EnableRawLogLine,
+		hosts:            hosts,
+		originalHost:     cfg.Host.OriginalField,
+		debugPayloads:    cfg.Log.DebugPayloads,
+		facility:         template.Must(template.New("facility").Parse(cfg.GELF.FacilityFormat)),
+		fieldTypes:       map[string]string{},
+		timestampSources: cfg.Timestamp.Sources,
+		maxFuture:        cfg.Timestamp.MaxFuture,
+	}
+	if cfg.GELF.EmptyMessages == config.EmptyPlaceholder {
+		p.placeholder = cfg.GELF.Placeholder
+	}
+	for name, kind := range cfg.GELF.FieldTypes {
+		p.fieldTypes[gelf.FieldName(name)] = kind
+	}
+	if cfg.Input.Oversize == config.OversizeTruncate {
+		p.maxMessageSize = cfg.Input.MaxEntrySize
+	}
+
+	// The stages holding records back are flushed periodically, each
+	// flushing the records that waited for longer than its timeout.
+	var timeouts []time.Duration
+	if cfg.Docker.JoinPartial {
+		p.stages = append(p.stages, container.NewAssembler(cfg.Docker.PartialMaxSize, cfg.Docker.PartialTimeout))
+		timeouts = append(timeouts, cfg.Docker.PartialTimeout)
+	}
+	if cfg.Multiline.Enabled {
+		joiner, err := multiline.New(cfg.Multiline.Start, cfg.Multiline.Continuation,
+			cfg.Multiline.FlushTimeout, cfg.Multiline.MaxLines, cfg.Input.MaxEntrySize)
 		if err != nil {
-			panic(err)
+			logging.Fatalf("Could not build the multiline stage: %s", err)
 		}
-		if overflow {
-			log.Println("Got a log line that was bigger than the allocated buffer, it will be skipped.")
-			continue
+		p.stages = append(p.stages, joiner)
+		timeouts = append(timeouts, cfg.Multiline.FlushTimeout)
+	}
+	if cfg.Docker.Enrich {
+		p.enrichers = append(p.enrichers, container.NewEnricher(cfg.Docker.Socket, cfg.Docker.Labels, cfg.Docker.CacheSize))
+	}
+	if cfg.Kubernetes.Metadata || cfg.Kubernetes.Enrich {
+		var watcher *k8s.Watcher
+		if cfg.Kubernetes.Enrich {
+			watcher, err = k8s.NewInClusterWatcher(cfg.Kubernetes.Node)
+			if err != nil {
+				logging.Fatalf("Could not watch the Kubernetes pods: %s", err)
+			}
+			go watcher.Run()
 		}
-		if b.IsBlacklisted(line) {
-			continue
+		p.enrichers = append(p.enrichers, k8s.NewEnricher(watcher, cfg.Kubernetes.Labels, cfg.Kubernetes.Annotations))
+	}
+	if cfg.JSON.Enabled {
+		p.enrichers = append(p.enrichers, parser.NewJSON(cfg.JSON))
+	}
+	if cfg.Logfmt.Enabled {
+		p.enrichers = append(p.enrichers, parser.NewLogfmt(cfg.Logfmt))
+	}
+	if len(cfg.Grok.Rules) > 0 {
+		grok, err := parser.NewGrok(cfg.Grok)
+		if err != nil {
+			logging.Fatalf("Could not build the grok stage: %s", err)
 		}
+		p.enrichers = append(p.enrichers, grok)
+	}
+	// The redaction comes last, so that the fields added by the other
+	// enrichers are redacted too.
+	if redactor != nil {
+		p.enrichers = append(p.enrichers, redactor)
+	}
 
-		gelfPayload := prepareGelfPayload(enableRawLogLine, line, defaultHostname)
-		if gelfPayload == "" {
-			continue
+	var flushes <-chan time.Time
+	if len(timeouts) > 0 {
+		interval := timeouts[0]
+		for _, t := range timeouts {
+			if t < interval {
+				interval = t
+			}
 		}
-
-		if *verbose {
-			log.Println(gelfPayload)
+		interval /= 2
+		if interval < 10*time.Millisecond {
+			interval = 10 * time.Millisecond
 		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		flushes = ticker.C
+	}
 
-		err = graylog.Log(gelfPayload)
-		if err != nil {
-			panic(err)
+	// Loop and process entries from stdin until EOF.
+	lines := readInput(status)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				p.flushAll()
+				// Wait for the queued messages to be sent before exiting,
+				// the queue is only closed once the hook is no longer
+				// called.
+				logging.SetHook(nil)
+				close(queue)
+				<-done
+				return
+			}
+			p.processLine(line, time.Now())
+		case now := <-flushes:
+			p.flush(now)
 		}
 	}
+}
 
+// readInput reads the journald JSON log lines from stdin, and returns them
+// over a channel that is closed on EOF. The oversize lines are only kept if
+// their message is to be truncated.
+func readInput(status *health.Status) <-chan []byte {
+	truncate := cfg.Input.Oversize == config.OversizeTruncate
+	reader := journald.NewReader(os.Stdin, cfg.Input.MaxEntrySize, truncate)
+	lines := make(chan []byte, 100)
+	go func() {
+		defer close(lines)
+		for {
+			line, oversize, err := reader.ReadLine()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				logging.Fatalf("Could not read the input: %s", err)
+			}
+			metrics.LinesRead.Inc()
+			status.Read()
+			if oversize {
+				metrics.OversizedLines.Inc()
+				if !truncate {
+					logging.WithFields(logging.Fields{"max_entry_size": cfg.Input.MaxEntrySize}).Warnf("Got a log line that was bigger than the maximum entry size, it will be skipped.")
+					continue
+				}
+			}
+			// The line is copied as the reader reuses its buffer.
+			lines <- append([]byte(nil), line...)
+		}
+	}()
+	return lines
 }
 
-func prepareGelfPayload(enableRawLogLine *bool, line []byte, defaultHostname string) string {
-	var logEntry journald.JournaldJSONLogEntry
-	var gelfLogEntry gelf.GELFLogEntry
+// serveHTTP exposes the metrics and the health probes over HTTP, it exits if
+// the listener cannot be started.
+func serveHTTP(address string, status *health.Status) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", status.LiveHandler())
+	mux.Handle("/readyz", status.ReadyHandler())
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		logging.Fatalf("Could not start the HTTP listener: %s", err)
+	}
+}
+
+// gelfLevels maps our log levels to the syslog severities used by GELF.
+var gelfLevels = map[logging.Level]int{
+	logging.ErrorLevel: 3,
+	logging.WarnLevel:  4,
+	logging.InfoLevel:  6,
+	logging.DebugLevel: 7,
+}
 
-	err := json.Unmarshal(line, &logEntry)
+// diagnosticMessage builds the GELF message shipping one of our own log
+// records, the debug records are not shipped as they include the payloads.
+// The message and the fields of the record are redacted if redactor is not
+// nil.
+func diagnosticMessage(r logging.Record, host string, facility string, redactor *redact.Redactor) *output.Message {
+	if r.Level > logging.InfoLevel {
+		return nil
+	}
+	extra := map[string]interface{}{}
+	for k, v := range r.Fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		if text, ok := v.(string); ok && redactor != nil {
+			v = redactor.Redact(text)
+		}
+		extra["_"+k] = v
+	}
+	if redactor != nil {
+		r.Message = redactor.Redact(r.Message)
+	}
+	fields := map[string]interface{}{}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	fields["version"] = "1.1"
+	fields["host"] = host
+	fields["short_message"] = r.Message
+	fields["timestamp"] = float64(r.Time.UnixNano()) / float64(time.Second)
+	fields["level"] = gelfLevels[r.Level]
+	fields["facility"] = facility
+	payload, err := json.Marshal(fields)
 	if err != nil {
-		log.Printf("The following log line was not a correctly JSON encoded, it will be skiped: \"%s\"\n", line)
-		return ""
+		return nil
 	}
+	// The record is what the outputs using other formats than GELF send.
+	record := &journald.Record{
+		Entry: journald.JournaldJSONLogEntry{
+			Message:          r.Message,
+			Priority:         strconv.Itoa(gelfLevels[r.Level]),
+			SyslogIdentifier: facility,
+		},
+		Line:   payload,
+		Fields: extra,
+	}
+	return &output.Message{Line: payload, Payload: payload, Record: record, Host: host, Time: r.Time, Diagnostic: true}
+}
 
-	if *enableRawLogLine {
-		gelfLogEntry.RawLogLine = string(line)
+// loadConfigFile looks for the configuration file given either by the --config
+// flag or by the J2G_CONFIG environment variable, and loads it into cfg.
+func loadConfigFile(args []string) error {
+	path := os.Getenv("J2G_CONFIG")
+	context, err := kingpin.CommandLine.ParseContext(args)
+	if err == nil {
+		for _, element := range context.Elements {
+			if flag, ok := element.Clause.(*kingpin.FlagClause); ok && flag.Model().Name == "config" {
+				path = *element.Value
+			}
+		}
 	}
-	gelfLogEntry.Version = "1.1"
-	if logEntry.Hostname == "" || logEntry.Hostname == "localhost" {
-		gelfLogEntry.Host = defaultHostname
-	} else {
-		gelfLogEntry.Host = logEntry.Hostname
+	if path == "" {
+		return nil
 	}
-	gelfLogEntry.Level, err = strconv.Atoi(logEntry.Priority)
+	return config.Load(path, cfg)
+}
+
+// parseRecord parses a journald JSON log line, it returns nil if the line is
+// not valid.
+func parseRecord(line []byte, maxMessageSize int) *journald.Record {
+	record := &journald.Record{Line: line}
+
+	err := json.Unmarshal(line, &record.Entry)
 	if err != nil {
-		panic(err)
+		logging.WithFields(logging.Fields{"line": string(line)}).Warnf("The log line was not correctly JSON encoded, it will be skipped.")
+		return nil
 	}
-	gelfLogEntry.ShortMessage = logEntry.Message
-	var jts = logEntry.RealtimeTimestamp
-	gelfLogEntry.Timestamp, _ = strconv.ParseFloat(fmt.Sprintf("%s.%s", jts[:10], jts[10:]), 64)
-	if (logEntry.SyslogFacility != "") && (logEntry.SyslogIdentifier != "") {
-		gelfLogEntry.Facility = fmt.Sprintf("%s (%s)", logEntry.SyslogFacility, logEntry.SyslogIdentifier)
-	} else if logEntry.SyslogFacility != "" {
-		gelfLogEntry.Facility = logEntry.SyslogFacility
-	} else if logEntry.SyslogIdentifier != "" {
-		gelfLogEntry.Facility = logEntry.SyslogIdentifier
-	} else {
-		gelfLogEntry.Facility = "Undefined"
+
+	if record.Entry.TruncateMessage(maxMessageSize) {
+		metrics.TruncatedMessages.Inc()
+		record.Truncated = true
+	}
+	return record
+}
+
+// prepareGelfPayload builds the GELF payload of a record, it returns an
+// error if the record lacks a field GELF requires.
+func prepareGelfPayload(enableRawLogLine *bool, record *journald.Record, host string, timestamp time.Time, facility string) (string, error) {
+	var gelfLogEntry gelf.GELFLogEntry
+	logEntry := &record.Entry
+
+	// The raw log line of a truncated entry is not sent, as it would be as
+	// big as the message that was truncated.
+	if record.Truncated {
+		gelfLogEntry.Truncated = true
+	} else if *enableRawLogLine {
+		gelfLogEntry.RawLogLine = string(record.Line)
+	}
+	gelfLogEntry.Version = gelf.Version
+	gelfLogEntry.Host = host
+	// The entries without a valid priority are informational, as journald
+	// logs them by default.
+	gelfLogEntry.Level = journald.PriorityInfo
+	if level := gelf.ParseInt(logEntry.Priority); level != nil && journald.PriorityName(*level) != "" {
+		gelfLogEntry.Level = *level
 	}
+	gelfLogEntry.LevelName = journald.PriorityName(gelfLogEntry.Level)
+	gelfLogEntry.ShortMessage = logEntry.Message
+	gelfLogEntry.FullMessage = record.FullMessage
+	gelfLogEntry.Timestamp = gelf.Timestamp(timestamp)
+	gelfLogEntry.Facility = facility
 	gelfLogEntry.BootID = logEntry.BootID
 	gelfLogEntry.MachineID = logEntry.MachineID
-	gelfLogEntry.PID = logEntry.PID
-	gelfLogEntry.UID = logEntry.UID
-	gelfLogEntry.GID = logEntry.GID
+	gelfLogEntry.PID = gelf.ParseInt(logEntry.PID)
+	gelfLogEntry.UID = gelf.ParseInt(logEntry.UID)
+	gelfLogEntry.GID = gelf.ParseInt(logEntry.GID)
+	gelfLogEntry.Command = logEntry.Command
 	gelfLogEntry.Executable = logEntry.Executable
 	gelfLogEntry.CommandLine = logEntry.CommandLine
-	var lineNumber int
-	if logEntry.CodeLine != "" {
-		lineNumber, err = strconv.Atoi(logEntry.CodeLine)
-		if err != nil {
-			return ""
-		}
-		// GELF: Line
-		gelfLogEntry.Line = &lineNumber
-		// GELF: File
-		gelfLogEntry.File = logEntry.CodeFile
-		// GELF: Function
-		gelfLogEntry.Function = logEntry.CodeFunction
-	}
+	// GELF: Line, File. The line is left out if it is not a number.
+	gelfLogEntry.Line = gelf.ParseInt(logEntry.CodeLine)
+	gelfLogEntry.File = logEntry.CodeFile
+	gelfLogEntry.Function = logEntry.CodeFunction
 	gelfLogEntry.Transport = logEntry.Transport
-	gelfPayloadBytes, err := json.Marshal(gelfLogEntry)
+	gelfLogEntry.ContainerID = logEntry.ContainerID
+	gelfLogEntry.ContainerIDFull = logEntry.ContainerIDFull
+	gelfLogEntry.ContainerName = logEntry.ContainerName
+	gelfLogEntry.ContainerTag = logEntry.ContainerTag
+	gelfLogEntry.ImageName = logEntry.ImageName
+	gelfLogEntry.AdditionalFields = record.Fields
+	gelfPayloadBytes, err := gelf.Encode(gelfLogEntry)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	gelfPayload := string(gelfPayloadBytes)
-	return gelfPayload
+	return string(gelfPayloadBytes), nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/host"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/output"
+	"github.com/cdemers/journald2graylog/redact"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestGolden processes the journald JSON log lines of the testdata/*.json
+// files, and compares the GELF payloads to the testdata/*.golden files.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 3, 14, 16, 0, 0, 0, time.UTC)
+	defaults := config.Default()
+
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queue := make(chan *output.Message, 100)
+		p := &processor{
+			queue:            queue,
+			hosts:            host.NewResolver(defaults.Host.Strategy, "default-host"),
+			facility:         template.Must(template.New("facility").Parse(defaults.GELF.FacilityFormat)),
+			timestampSources: defaults.Timestamp.Sources,
+			maxFuture:        defaults.Timestamp.MaxFuture,
+			placeholder:      defaults.GELF.Placeholder,
+		}
+		for _, line := range bytes.Split(bytes.TrimSpace(input), []byte("\n")) {
+			p.processLine(line, now)
+		}
+		close(queue)
+		var payloads []string
+		for msg := range queue {
+			payloads = append(payloads, string(msg.Payload))
+		}
+		actual := strings.Join(payloads, "\n") + "\n"
+
+		golden := strings.TrimSuffix(file, ".json") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != string(expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", file, expected, actual)
+		}
+	}
+}
+
+func TestDiagnosticMessageRedacted(t *testing.T) {
+	redactor, err := redact.New(config.Default().Redact, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := logging.Record{
+		Time:    time.Now(),
+		Level:   logging.WarnLevel,
+		Message: "The log line was not correctly JSON encoded, it will be skipped.",
+		Fields:  logging.Fields{"line": `{"MESSAGE":"login user=jane password=hunter2"`},
+	}
+
+	msg := diagnosticMessage(r, "node-1", "journald2graylog", redactor)
+	if strings.Contains(string(msg.Payload), "hunter2") || msg.Record.Fields["_line"] != `{"MESSAGE":"login user=jane password=[REDACTED]"` {
+		t.Errorf("the diagnostic should be redacted, got %s", msg.Payload)
+	}
+	if msg := diagnosticMessage(r, "node-1", "journald2graylog", nil); !strings.Contains(string(msg.Payload), "hunter2") {
+		t.Errorf("the diagnostic should only be redacted along with the entries, got %s", msg.Payload)
+	}
+}
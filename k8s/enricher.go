@@ -0,0 +1,87 @@
+package k8s
+
+import (
+	"github.com/cdemers/journald2graylog/gelf"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Enricher adds the namespace, the pod and the container that logged an
+// entry to it, as found in the name of its Docker container or in its cgroup
+// path, along with the labels and the annotations of the pod when a watcher
+// is given.
+type Enricher struct {
+	watcher     *Watcher
+	labels      map[string]bool
+	annotations map[string]bool
+}
+
+// NewEnricher returns an enricher adding the metadata of the pods known to
+// the watcher, which may be nil. Only the labels listed are added, or all of
+// them if none are, and only the annotations listed are added.
+func NewEnricher(watcher *Watcher, labels, annotations []string) *Enricher {
+	e := &Enricher{
+		watcher:     watcher,
+		annotations: map[string]bool{},
+	}
+	if len(labels) > 0 {
+		e.labels = map[string]bool{}
+		for _, label := range labels {
+			e.labels[label] = true
+		}
+	}
+	for _, annotation := range annotations {
+		e.annotations[annotation] = true
+	}
+	return e
+}
+
+// Enrich adds the metadata of the pod that logged the entry, if any, to the
+// record.
+func (e *Enricher) Enrich(r *journald.Record) {
+	ref, ok := ParseContainerName(r.Entry.ContainerName)
+	if cgroup, found := ParseCgroup(r.Entry.SystemdCGroup); found {
+		if !ok {
+			ref = cgroup
+			ok = true
+		} else if ref.PodUID == cgroup.PodUID {
+			ref.QOSClass = cgroup.QOSClass
+		}
+	}
+	if !ok {
+		return
+	}
+
+	var pod *Pod
+	if e.watcher != nil {
+		pod = e.watcher.Pod(ref.PodUID)
+	}
+	if pod != nil {
+		ref.Pod = pod.Name
+		ref.Namespace = pod.Namespace
+	}
+
+	setField(r, "KubernetesNamespace", ref.Namespace)
+	setField(r, "KubernetesPodName", ref.Pod)
+	setField(r, "KubernetesPodUID", ref.PodUID)
+	setField(r, "KubernetesContainerName", ref.Container)
+	setField(r, "KubernetesQOSClass", ref.QOSClass)
+	if pod == nil {
+		return
+	}
+	for label, value := range pod.Labels {
+		if e.labels == nil || e.labels[label] {
+			r.SetField(gelf.FieldName("KubernetesLabel_"+label), value)
+		}
+	}
+	for annotation, value := range pod.Annotations {
+		if e.annotations[annotation] {
+			r.SetField(gelf.FieldName("KubernetesAnnotation_"+annotation), value)
+		}
+	}
+}
+
+func setField(r *journald.Record, name, value string) {
+	if value != "" {
+		r.SetField(name, value)
+	}
+}
@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"regexp"
+	"strings"
+)
+
+// QoS classes of the pods, as found in their cgroup paths.
+const (
+	QOSGuaranteed = "guaranteed"
+	QOSBurstable  = "burstable"
+	QOSBestEffort = "besteffort"
+)
+
+// Ref identifies the pod, and the container, that logged an entry.
+type Ref struct {
+	Container string
+	Pod       string
+	Namespace string
+	PodUID    string
+	QOSClass  string
+}
+
+// The names given by the kubelet to the Docker containers it creates:
+// k8s_<container>_<pod>_<namespace>_<pod UID>_<restart count>.
+var containerName = regexp.MustCompile(`^/?k8s_([^_]+)_([^_]+)_([^_]+)_([^_]+)_\d+$`)
+
+// ParseContainerName returns the pod a Docker container belongs to, from the
+// name given to the container by the kubelet.
+func ParseContainerName(name string) (Ref, bool) {
+	m := containerName.FindStringSubmatch(name)
+	if m == nil {
+		return Ref{}, false
+	}
+	return Ref{
+		Container: m[1],
+		Pod:       m[2],
+		Namespace: m[3],
+		PodUID:    m[4],
+	}, true
+}
+
+// ParseCgroup returns the UID and the QoS class of the pod a process belongs
+// to, from its cgroup path. Both the systemd layout, such as
+// /kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<UID>.slice,
+// where the dashes of the UID are replaced with underscores, and the cgroupfs
+// layout, such as /kubepods/burstable/pod<UID>, are understood.
+func ParseCgroup(path string) (Ref, bool) {
+	var ref Ref
+	kubepods := false
+	for _, segment := range strings.Split(path, "/") {
+		// The cgroups of the containers managed by containerd with the
+		// systemd driver are named <slice>:<prefix>:<container ID>.
+		if i := strings.IndexByte(segment, ':'); i >= 0 {
+			segment = segment[:i]
+		}
+		segment = strings.TrimSuffix(segment, ".slice")
+		if strings.HasPrefix(segment, "kubepods") {
+			kubepods = true
+		}
+		if !kubepods {
+			continue
+		}
+
+		switch {
+		case segment == QOSBurstable || strings.HasPrefix(segment, "kubepods-"+QOSBurstable):
+			ref.QOSClass = QOSBurstable
+		case segment == QOSBestEffort || strings.HasPrefix(segment, "kubepods-"+QOSBestEffort):
+			ref.QOSClass = QOSBestEffort
+		}
+		if strings.HasPrefix(segment, "pod") {
+			ref.PodUID = segment[len("pod"):]
+		} else if i := strings.LastIndex(segment, "-pod"); i >= 0 {
+			ref.PodUID = strings.Replace(segment[i+len("-pod"):], "_", "-", -1)
+		}
+	}
+	if ref.PodUID == "" {
+		return Ref{}, false
+	}
+	if ref.QOSClass == "" {
+		ref.QOSClass = QOSGuaranteed
+	}
+	return ref, true
+}
@@ -0,0 +1,51 @@
+package k8s
+
+import "testing"
+
+func TestParseContainerName(t *testing.T) {
+	ref, ok := ParseContainerName("k8s_nginx_web-5d8f7c9b4-x2x7q_default_3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc_2")
+	expected := Ref{
+		Container: "nginx",
+		Pod:       "web-5d8f7c9b4-x2x7q",
+		Namespace: "default",
+		PodUID:    "3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc",
+	}
+	if !ok || ref != expected {
+		t.Errorf("unexpected reference %+v", ref)
+	}
+
+	for _, name := range []string{"", "nginx", "k8s_nginx_web_default", "k8s_nginx_web_default_uid_x"} {
+		if _, ok := ParseContainerName(name); ok {
+			t.Errorf("%q is not the name of a container of a pod", name)
+		}
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	tests := []struct {
+		path string
+		uid  string
+		qos  string
+	}{
+		{"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod3d8c1b2e_5f6a_11e8_9c2d_fa7ae01bbebc.slice/docker-0123.scope",
+			"3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc", QOSBurstable},
+		{"/kubepods.slice/kubepods-pod3d8c1b2e_5f6a_11e8_9c2d_fa7ae01bbebc.slice/cri-containerd-0123.scope",
+			"3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc", QOSGuaranteed},
+		{"/kubepods/besteffort/pod3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc/0123",
+			"3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc", QOSBestEffort},
+		{"/system.slice/containerd.service/kubepods-besteffort-pod3d8c1b2e_5f6a_11e8_9c2d_fa7ae01bbebc.slice:cri-containerd:0123",
+			"3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc", QOSBestEffort},
+	}
+	for _, test := range tests {
+		ref, ok := ParseCgroup(test.path)
+		if !ok || ref.PodUID != test.uid || ref.QOSClass != test.qos {
+			t.Errorf("%s: unexpected reference %+v", test.path, ref)
+		}
+	}
+
+	for _, path := range []string{"", "/system.slice/sshd.service", "/user.slice/user-1000.slice/pod.scope"} {
+		if _, ok := ParseCgroup(path); ok {
+			t.Errorf("%q is not the cgroup of a pod", path)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdemers/journald2graylog/logging"
+)
+
+// The files where the service account of a pod is mounted.
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// watchTimeout is how long the API server keeps a watch open, it is
+	// restarted from the last version seen once closed.
+	watchTimeout = 5 * time.Minute
+	retryDelay   = 5 * time.Second
+	// deletedRetention is how long a deleted pod is remembered, as its last
+	// entries may be read after it is gone.
+	deletedRetention = 5 * time.Minute
+)
+
+// Pod is the metadata of a pod, as returned by the Kubernetes API.
+type Pod struct {
+	UID         string            `json:"uid"`
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+
+	deleted time.Time
+}
+
+// Watcher keeps the metadata of the pods running on a node up to date, by
+// watching them through the Kubernetes API.
+type Watcher struct {
+	client    *http.Client
+	server    string
+	tokenFile string
+	node      string
+
+	mutex sync.RWMutex
+	pods  map[string]*Pod
+}
+
+// NewInClusterWatcher returns a watcher of the pods of the given node, that
+// authenticates with the service account of the pod it runs in.
+func NewInClusterWatcher(node string) (*Watcher, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", serviceAccountCA)
+	}
+	transport := &http.Transport{
+		TLSClientConfig:       &tls.Config{RootCAs: pool},
+		ResponseHeaderTimeout: requestTimeout,
+	}
+	return newWatcher(&http.Client{Transport: transport}, "https://"+net.JoinHostPort(host, port), serviceAccountToken, node), nil
+}
+
+func newWatcher(client *http.Client, server, tokenFile, node string) *Watcher {
+	return &Watcher{
+		client:    client,
+		server:    server,
+		tokenFile: tokenFile,
+		node:      node,
+		pods:      map[string]*Pod{},
+	}
+}
+
+// Pod returns the metadata of the pod with the given UID, or nil if it is
+// unknown.
+func (w *Watcher) Pod(uid string) *Pod {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.pods[uid]
+}
+
+// Run lists, then watches, the pods of the node, forever. The errors are
+// logged, and the pods listed again after a delay.
+func (w *Watcher) Run() {
+	for {
+		version, err := w.list()
+		for err == nil {
+			version, err = w.watch(version)
+		}
+		logging.WithFields(logging.Fields{"node": w.node, "error": err}).Warnf("Could not watch the pods.")
+		time.Sleep(retryDelay)
+	}
+}
+
+// list replaces the known pods with those of the node, and returns the
+// version of the list.
+func (w *Watcher) list() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	response, err := w.get(ctx, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []struct {
+			Metadata Pod `json:"metadata"`
+		} `json:"items"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&list)
+	if err != nil {
+		return "", err
+	}
+
+	pods := map[string]*Pod{}
+	for i := range list.Items {
+		pod := list.Items[i].Metadata
+		pods[pod.UID] = &pod
+	}
+	w.mutex.Lock()
+	// The deleted pods that are not listed any more are still remembered.
+	now := time.Now()
+	for uid, pod := range w.pods {
+		if pods[uid] == nil && now.Sub(pod.deleted) < deletedRetention {
+			pods[uid] = pod
+		}
+	}
+	w.pods = pods
+	w.mutex.Unlock()
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch applies the changes to the pods of the node that happen after the
+// given version, until the API server closes the watch, and returns the last
+// version seen.
+func (w *Watcher) watch(version string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout+requestTimeout)
+	defer cancel()
+	response, err := w.get(ctx, url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {version},
+		"timeoutSeconds":  {fmt.Sprint(int(watchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var event struct {
+			Type   string `json:"type"`
+			Object struct {
+				Metadata struct {
+					Pod
+					ResourceVersion string `json:"resourceVersion"`
+				} `json:"metadata"`
+				// Message is set on the ERROR events, such as when the
+				// version is too old to be watched from.
+				Message string `json:"message"`
+			} `json:"object"`
+		}
+		err := decoder.Decode(&event)
+		if err != nil {
+			if err == io.EOF {
+				return version, nil
+			}
+			return "", err
+		}
+
+		pod := event.Object.Metadata.Pod
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			w.mutex.Lock()
+			w.pods[pod.UID] = &pod
+			w.mutex.Unlock()
+		case "DELETED":
+			w.delete(pod.UID, time.Now())
+		case "ERROR":
+			return "", fmt.Errorf("the watch failed: %s", event.Object.Message)
+		}
+		version = event.Object.Metadata.ResourceVersion
+	}
+}
+
+// delete marks a pod as deleted, and forgets the pods deleted for longer
+// than the retention.
+func (w *Watcher) delete(uid string, now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if pod := w.pods[uid]; pod != nil {
+		deleted := *pod
+		deleted.deleted = now
+		w.pods[uid] = &deleted
+	}
+	for uid, pod := range w.pods {
+		if !pod.deleted.IsZero() && now.Sub(pod.deleted) >= deletedRetention {
+			delete(w.pods, uid)
+		}
+	}
+}
+
+// get requests the pods of the node from the API server.
+func (w *Watcher) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	query.Set("fieldSelector", "spec.nodeName="+w.node)
+	request, err := http.NewRequest(http.MethodGet, w.server+"/api/v1/pods?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	// The token is read on every request, as it is rotated by the kubelet.
+	if w.tokenFile != "" {
+		token, err := ioutil.ReadFile(w.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("the Kubernetes API answered %s", response.Status)
+	}
+	return response, nil
+}
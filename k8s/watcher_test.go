@@ -0,0 +1,107 @@
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+const podUID = "3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc"
+
+func fakeAPIServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fieldSelector") != "spec.nodeName=node-1" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"10"},"items":[
+				{"metadata":{"uid":%q,"name":"web-0","namespace":"shop","labels":{"app":"web","tier":"front"},
+				"annotations":{"team":"ops","kubectl.kubernetes.io/last-applied-configuration":"{}"}}}]}`, podUID)
+			return
+		}
+		if version := r.URL.Query().Get("resourceVersion"); version != "10" {
+			t.Errorf("the watch should start from the listed version, got %q", version)
+		}
+		fmt.Fprintf(w, `{"type":"MODIFIED","object":{"metadata":{"uid":%q,"name":"web-0","namespace":"shop","resourceVersion":"11","labels":{"app":"web","tier":"back"}}}}
+{"type":"ADDED","object":{"metadata":{"uid":"other","name":"db-0","namespace":"shop","resourceVersion":"12"}}}
+{"type":"DELETED","object":{"metadata":{"uid":"other","name":"db-0","namespace":"shop","resourceVersion":"13"}}}
+`, podUID)
+	}))
+}
+
+func TestWatcher(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+	w := newWatcher(server.Client(), server.URL, "", "node-1")
+
+	version, err := w.list()
+	if err != nil || version != "10" {
+		t.Fatalf("unexpected list result %q, %v", version, err)
+	}
+	if pod := w.Pod(podUID); pod == nil || pod.Name != "web-0" || pod.Labels["tier"] != "front" {
+		t.Errorf("unexpected pod %+v", pod)
+	}
+
+	version, err = w.watch(version)
+	if err != nil || version != "13" {
+		t.Fatalf("unexpected watch result %q, %v", version, err)
+	}
+	if pod := w.Pod(podUID); pod == nil || pod.Labels["tier"] != "back" {
+		t.Errorf("the pod should be updated, got %+v", pod)
+	}
+	// The deleted pods are remembered for a while.
+	if pod := w.Pod("other"); pod == nil || pod.deleted.IsZero() {
+		t.Errorf("the deleted pod should be remembered, got %+v", pod)
+	}
+	w.delete("unknown", time.Now().Add(deletedRetention))
+	if pod := w.Pod("other"); pod != nil {
+		t.Errorf("the deleted pod should be forgotten, got %+v", pod)
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+	w := newWatcher(server.Client(), server.URL, "", "node-1")
+	if _, err := w.list(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEnricher(w, []string{"app"}, []string{"team"})
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		ContainerName: "k8s_nginx_web-0_shop_" + podUID + "_0",
+		SystemdCGroup: "/kubepods/burstable/pod" + podUID + "/0123",
+	}}
+	e.Enrich(r)
+	expected := map[string]interface{}{
+		"KubernetesNamespace":        "shop",
+		"KubernetesPodName":          "web-0",
+		"KubernetesPodUID":           podUID,
+		"KubernetesContainerName":    "nginx",
+		"KubernetesQOSClass":         QOSBurstable,
+		"_KubernetesLabel_app":       "web",
+		"_KubernetesAnnotation_team": "ops",
+	}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	// Without a watcher, only the metadata found in the entry is added.
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		SystemdCGroup: "/kubepods/besteffort/pod" + podUID + "/0123",
+	}}
+	NewEnricher(nil, nil, nil).Enrich(r)
+	if fmt.Sprint(r.Fields) != fmt.Sprint(map[string]interface{}{"KubernetesPodUID": podUID, "KubernetesQOSClass": QOSBestEffort}) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{SystemdUnit: "sshd.service"}}
+	NewEnricher(nil, nil, nil).Enrich(r)
+	if r.Fields != nil {
+		t.Errorf("an entry not logged by a pod should not be enriched, got %v", r.Fields)
+	}
+}
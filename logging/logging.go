@@ -0,0 +1,308 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log record.
+type Level int
+
+// The log levels, from the most to the least severe.
+const (
+	ErrorLevel Level = iota
+	WarnLevel
+	InfoLevel
+	DebugLevel
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (l Level) String() string {
+	if l < ErrorLevel || l > DebugLevel {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level matching its name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return ErrorLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+// The log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Fields are the structured data attached to a log record.
+type Fields map[string]interface{}
+
+// Record is a single log record, as it is given to the hook.
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  Fields
+}
+
+// Logger writes leveled log records in the text or JSON format.
+type Logger struct {
+	mutex  sync.Mutex
+	level  Level
+	format string
+	writer io.Writer
+	hook   func(Record)
+	// calls is the number of hook calls in progress, idle is signaled
+	// when it drops to zero.
+	calls int
+	idle  *sync.Cond
+}
+
+// New returns a logger writing text records of level info and above to w.
+func New(w io.Writer) *Logger {
+	l := &Logger{level: InfoLevel, format: FormatText, writer: w}
+	l.idle = sync.NewCond(&l.mutex)
+	return l
+}
+
+// Configure sets the level and the format of the logger.
+func (l *Logger) Configure(level Level, format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+	l.format = format
+	return nil
+}
+
+// SetHook registers a function called with every record written by the
+// logger, nil removes it. It returns once the calls to the previous hook
+// returned, so that what the hook uses can be released safely, which means
+// it must not be called by the hook itself.
+func (l *Logger) SetHook(hook func(Record)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.hook = hook
+	for l.calls > 0 {
+		l.idle.Wait()
+	}
+}
+
+// Enabled returns true if records of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return level <= l.level
+}
+
+func (l *Logger) log(level Level, fields Fields, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	r := Record{
+		Time:    time.Now(),
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+		Fields:  fields,
+	}
+
+	l.mutex.Lock()
+	var line []byte
+	if l.format == FormatJSON {
+		line = formatJSON(r)
+	} else {
+		line = formatText(r)
+	}
+	l.writer.Write(line)
+	hook := l.hook
+	if hook != nil {
+		l.calls++
+	}
+	l.mutex.Unlock()
+	if hook != nil {
+		l.callHook(hook, r)
+	}
+}
+
+// callHook calls the hook without holding the lock, so that it can log
+// itself, and signals SetHook once no call is in progress anymore.
+func (l *Logger) callHook(hook func(Record), r Record) {
+	defer func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.calls--
+		if l.calls == 0 {
+			l.idle.Broadcast()
+		}
+	}()
+	hook(r)
+}
+
+func formatText(r Record) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s level=%s msg=%q", r.Time.Format(time.RFC3339), r.Level, r.Message)
+	for _, k := range sortedKeys(r.Fields) {
+		v := fmt.Sprint(r.Fields[k])
+		if strings.ContainsAny(v, " \"=") || v == "" {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func formatJSON(r Record) []byte {
+	record := map[string]interface{}{}
+	for k, v := range r.Fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		record[k] = v
+	}
+	record["time"] = r.Time.Format(time.RFC3339Nano)
+	record["level"] = r.Level.String()
+	record["msg"] = r.Message
+	line, err := json.Marshal(record)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": r.Level.String(), "msg": r.Message, "error": err.Error()})
+	}
+	return append(line, '\n')
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Entry is a set of fields to be attached to a log record.
+type Entry struct {
+	logger *Logger
+	fields Fields
+}
+
+// WithFields returns an entry logging records with the given fields.
+func (l *Logger) WithFields(fields Fields) *Entry {
+	return &Entry{logger: l, fields: fields}
+}
+
+// Errorf logs a record of level error.
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.logger.log(ErrorLevel, e.fields, format, args...)
+}
+
+// Warnf logs a record of level warn.
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	e.logger.log(WarnLevel, e.fields, format, args...)
+}
+
+// Infof logs a record of level info.
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.logger.log(InfoLevel, e.fields, format, args...)
+}
+
+// Debugf logs a record of level debug.
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.logger.log(DebugLevel, e.fields, format, args...)
+}
+
+// Fatalf logs a record of level error and exits.
+func (e *Entry) Fatalf(format string, args ...interface{}) {
+	e.logger.log(ErrorLevel, e.fields, format, args...)
+	os.Exit(1)
+}
+
+// Writer returns a writer logging every line written to it as a record of
+// the given level, it allows the standard library logger to be redirected.
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			l.log(level, nil, "%s", line)
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// std is the logger used by the package level functions, it writes to stderr.
+var std = New(os.Stderr)
+
+// Configure sets the level and the format of the standard logger.
+func Configure(level Level, format string) error {
+	return std.Configure(level, format)
+}
+
+// SetHook registers a function called with every record written by the
+// standard logger, it returns once the calls to the previous hook returned.
+func SetHook(hook func(Record)) {
+	std.SetHook(hook)
+}
+
+// Enabled returns true if records of the level are written by the standard
+// logger.
+func Enabled(level Level) bool {
+	return std.Enabled(level)
+}
+
+// Writer returns a writer logging every line written to it with the
+// standard logger.
+func Writer(level Level) io.Writer {
+	return std.Writer(level)
+}
+
+// WithFields returns an entry logging records with the given fields to the
+// standard logger.
+func WithFields(fields Fields) *Entry {
+	return std.WithFields(fields)
+}
+
+// Errorf logs a record of level error with the standard logger.
+func Errorf(format string, args ...interface{}) {
+	std.log(ErrorLevel, nil, format, args...)
+}
+
+// Warnf logs a record of level warn with the standard logger.
+func Warnf(format string, args ...interface{}) {
+	std.log(WarnLevel, nil, format, args...)
+}
+
+// Infof logs a record of level info with the standard logger.
+func Infof(format string, args ...interface{}) {
+	std.log(InfoLevel, nil, format, args...)
+}
+
+// Debugf logs a record of level debug with the standard logger.
+func Debugf(format string, args ...interface{}) {
+	std.log(DebugLevel, nil, format, args...)
+}
+
+// Fatalf logs a record of level error with the standard logger and exits.
+func Fatalf(format string, args ...interface{}) {
+	std.log(ErrorLevel, nil, format, args...)
+	os.Exit(1)
+}
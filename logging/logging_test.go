@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out)
+	l.Configure(WarnLevel, FormatText)
+
+	l.WithFields(nil).Infof("hidden")
+	l.WithFields(nil).Warnf("shown")
+
+	if strings.Contains(out.String(), "hidden") || !strings.Contains(out.String(), `level=warn msg="shown"`) {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("DEBUG")
+	if err != nil || level != DebugLevel {
+		t.Errorf("unexpected level %s (%v)", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("an unknown level should be reported")
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out)
+
+	l.WithFields(Fields{"output": "primary", "reason": "connection refused"}).Errorf("Send failed")
+
+	if !strings.HasSuffix(out.String(), ` level=error msg="Send failed" output=primary reason="connection refused"`+"\n") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out)
+	l.Configure(InfoLevel, FormatJSON)
+
+	l.WithFields(Fields{"output": "primary"}).Infof("Sent %d messages", 3)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["level"] != "info" || record["msg"] != "Sent 3 messages" || record["output"] != "primary" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestHook(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out)
+	var records []Record
+	l.SetHook(func(r Record) { records = append(records, r) })
+
+	l.Writer(WarnLevel).Write([]byte("first\nsecond\n"))
+
+	if len(records) != 2 || records[0].Message != "first" || records[1].Level != WarnLevel {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestSetHookWaitsForCalls(t *testing.T) {
+	l := New(ioutil.Discard)
+	called, release := make(chan struct{}), make(chan struct{})
+	l.SetHook(func(r Record) {
+		close(called)
+		<-release
+	})
+	go l.WithFields(nil).Infof("hooked")
+	<-called
+
+	// The hook is not removed while it is called, so that what it uses can
+	// be released as soon as it is.
+	removed := make(chan struct{})
+	go func() {
+		l.SetHook(nil)
+		close(removed)
+	}()
+	select {
+	case <-removed:
+		t.Fatal("the hook was removed while it was called")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-removed
+	l.WithFields(nil).Infof("not hooked")
+}
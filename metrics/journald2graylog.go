@@ -0,0 +1,30 @@
+package metrics
+
+// The metrics exposed by journald2graylog.
+var (
+	LinesRead         = NewCounter("journald2graylog_lines_read_total", "Number of log lines read from the input.", "")
+	ParseFailures     = NewCounter("journald2graylog_parse_failures_total", "Number of log lines that could not be parsed and were skipped.", "")
+	Blacklisted       = NewCounter("journald2graylog_blacklisted_total", "Number of log lines matching the blacklist, that were not sent.", "")
+	OversizedLines    = NewCounter("journald2graylog_oversized_lines_total", "Number of log lines bigger than the maximum entry size.", "")
+	TruncatedMessages = NewCounter("journald2graylog_truncated_messages_total", "Number of messages truncated to fit the maximum entry size.", "")
+	InvalidTimestamps = NewCounter("journald2graylog_invalid_timestamps_total", "Number of entries without a valid timestamp, dated with the time they were sent at.", "")
+	InvalidEntries    = NewCounter("journald2graylog_invalid_entries_total", "Number of entries that could not be turned into valid GELF messages and were skipped.", "")
+
+	EmptyMessages = NewCounter("journald2graylog_empty_messages_total", "Number of entries with an empty message, by action: placeholder or drop.", "action")
+
+	GrokMatches = NewCounter("journald2graylog_grok_matches_total", "Number of messages matching a grok rule, by rule.", "rule")
+	GrokMisses  = NewCounter("journald2graylog_grok_misses_total", "Number of messages selected by a grok rule but not matching it, by rule.", "rule")
+
+	Redactions = NewCounter("journald2graylog_redactions_total", "Number of sensitive values redacted, by detector.", "detector")
+
+	MessagesSent = NewCounter("journald2graylog_messages_sent_total", "Number of messages sent, by output.", "output")
+	BytesSent    = NewCounter("journald2graylog_bytes_sent_total", "Number of payload bytes sent, before compression, by output.", "output")
+	ChunksSent   = NewCounter("journald2graylog_chunks_sent_total", "Number of GELF UDP chunks sent, by output.", "output")
+	SendErrors   = NewCounter("journald2graylog_send_errors_total", "Number of messages that could not be sent, by output.", "output")
+	SendLatency  = NewHistogram("journald2graylog_send_latency_seconds", "Time spent sending a single message, by output.", "output", DefaultBuckets)
+
+	TruncatedPayloads = NewCounter("journald2graylog_truncated_payloads_total", "Number of payloads truncated to fit the size limit of an output, by output.", "output")
+	DroppedPayloads   = NewCounter("journald2graylog_dropped_payloads_total", "Number of payloads too big for an output, that were not sent, by output.", "output")
+
+	RejectedDocuments = NewCounter("journald2graylog_rejected_documents_total", "Number of documents rejected by the bulk API, by output.", "output")
+)
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is implemented by every metric, it writes the metric in the
+// Prometheus text exposition format.
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      []collector
+)
+
+func register(c collector) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, c)
+}
+
+// Handler returns the HTTP handler exposing all the registered metrics in the
+// Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registryMutex.Lock()
+		defer registryMutex.Unlock()
+		for _, c := range registry {
+			c.write(w)
+		}
+	})
+}
+
+// desc holds the name, help and optional label name shared by all metrics.
+type desc struct {
+	name  string
+	help  string
+	label string
+}
+
+func (d *desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// labels formats the label set of a sample, extra being an already formatted
+// additional label such as the "le" label of histogram buckets.
+func (d *desc) labels(value string, extra string) string {
+	var pairs []string
+	if d.label != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", d.label, value))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelValue returns the single label value of a sample, or an empty string
+// for unlabeled metrics.
+func labelValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// vector holds the values of a counter or a gauge, by label value.
+type vector struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (v *vector) add(delta float64, label string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[label] += delta
+}
+
+func (v *vector) set(value float64, label string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[label] = value
+}
+
+func (v *vector) writeValues(w io.Writer, kind string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.header(w, kind)
+	for _, label := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labels(label, ""), formatValue(v.values[label]))
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a metric that can only go up, optionally partitioned by the
+// value of a single label.
+type Counter struct {
+	vector
+}
+
+// NewCounter creates and registers a counter, label is the name of its label
+// or an empty string if it has none.
+func NewCounter(name, help, label string) *Counter {
+	c := &Counter{vector{desc: desc{name, help, label}, values: map[string]float64{}}}
+	if label == "" {
+		c.values[""] = 0
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the counter by one, for the given label value if the
+// counter has a label.
+func (c *Counter) Inc(label ...string) {
+	c.add(1, labelValue(label))
+}
+
+// Add increments the counter by delta, for the given label value if the
+// counter has a label.
+func (c *Counter) Add(delta float64, label ...string) {
+	c.add(delta, labelValue(label))
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeValues(w, "counter")
+}
+
+// GaugeFunc is a metric whose value is computed when it is collected.
+type GaugeFunc struct {
+	desc
+	function func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge whose value is returned by f.
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, function: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.function()))
+}
+
+// Histogram samples observations in buckets, optionally partitioned by the
+// value of a single label.
+type Histogram struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// DefaultBuckets are the buckets, in seconds, suited to most latencies.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram creates and registers a histogram with the given upper bounds
+// of its buckets, label is the name of its label or an empty string if it has
+// none.
+func NewHistogram(name, help, label string, buckets []float64) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, label},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	register(h)
+	return h
+}
+
+// Observe adds a single observation to the histogram, for the given label
+// value if the histogram has a label.
+func (h *Histogram) Observe(value float64, label ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s, ok := h.series[labelValue(label)]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue(label)] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.header(w, "histogram")
+	labels := make([]string, 0, len(h.series))
+	for label := range h.series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		s := h.series[label]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(label, fmt.Sprintf("le=%q", formatValue(bound))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(label, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(label, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(label, ""), s.count)
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	return recorder.Body.String()
+}
+
+func expectLines(t *testing.T, body string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	plain := NewCounter("test_plain_total", "A plain counter.", "")
+	labeled := NewCounter("test_labeled_total", "A labeled counter.", "output")
+
+	plain.Inc()
+	plain.Add(2)
+	labeled.Inc("primary")
+	labeled.Add(1.5, "dr")
+
+	expectLines(t, scrape(t),
+		"# TYPE test_plain_total counter",
+		"test_plain_total 3",
+		`test_labeled_total{output="dr"} 1.5`,
+		`test_labeled_total{output="primary"} 1`,
+	)
+}
+
+func TestGaugeFunc(t *testing.T) {
+	NewGaugeFunc("test_gauge", "A gauge.", func() float64 { return 42 })
+
+	expectLines(t, scrape(t), "# TYPE test_gauge gauge", "test_gauge 42")
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "A histogram.", "output", []float64{0.1, 1})
+
+	h.Observe(0.05, "primary")
+	h.Observe(0.5, "primary")
+	h.Observe(5, "primary")
+
+	expectLines(t, scrape(t),
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{output="primary",le="0.1"} 1`,
+		`test_latency_seconds_bucket{output="primary",le="1"} 2`,
+		`test_latency_seconds_bucket{output="primary",le="+Inf"} 3`,
+		`test_latency_seconds_sum{output="primary"} 5.55`,
+		`test_latency_seconds_count{output="primary"} 3`,
+	)
+}
@@ -0,0 +1,164 @@
+package multiline
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Joiner joins the entries that continue a previous message, such as the
+// lines of a stack trace logged as separate entries, into a single record.
+// The entries are grouped by unit, PID and container, so that interleaved
+// messages of different processes are not mixed.
+type Joiner struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+	timeout      time.Duration
+	maxLines     int
+	maxBytes     int
+	pending      map[string]*group
+	sequence     int
+}
+
+// group is a record being assembled, along with its lines.
+type group struct {
+	record *journald.Record
+	lines  []string
+	size   int
+	last   time.Time
+	// sequence orders the groups by creation.
+	sequence int
+}
+
+// New returns a joiner. An entry continues the previous message of the same
+// process if it matches the continuation regex, or if it does not match the
+// start regex, at least one of them must be given. A record is flushed once
+// no line was added to it for the timeout, or once it reaches maxLines lines
+// or maxBytes bytes.
+func New(start, continuation string, timeout time.Duration, maxLines, maxBytes int) (*Joiner, error) {
+	if start == "" && continuation == "" {
+		return nil, fmt.Errorf("a start or a continuation regex is required")
+	}
+	j := &Joiner{
+		timeout:  timeout,
+		maxLines: maxLines,
+		maxBytes: maxBytes,
+		pending:  map[string]*group{},
+	}
+	var err error
+	if start != "" {
+		j.start, err = regexp.Compile(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start regex: %s", err)
+		}
+	}
+	if continuation != "" {
+		j.continuation, err = regexp.Compile(continuation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid continuation regex: %s", err)
+		}
+	}
+	return j, nil
+}
+
+// key identifies the process that logged an entry. The entries of the
+// Docker containers are all logged by the Docker daemon, they are told apart
+// by their container ID.
+func key(e *journald.JournaldJSONLogEntry) string {
+	unit := e.SystemdUnit
+	if unit == "" {
+		unit = e.SyslogIdentifier
+	}
+	container := e.ContainerIDFull
+	if container == "" {
+		container = e.ContainerID
+	}
+	return unit + "/" + e.PID + "/" + container
+}
+
+func (j *Joiner) continues(message string) bool {
+	if j.continuation != nil && j.continuation.MatchString(message) {
+		return true
+	}
+	return j.start != nil && !j.start.MatchString(message)
+}
+
+// Add gives a new record to the joiner, and returns the records that are
+// complete because of it.
+func (j *Joiner) Add(r *journald.Record, now time.Time) []*journald.Record {
+	k := key(&r.Entry)
+	g := j.pending[k]
+	if g != nil && j.continues(r.Entry.Message) {
+		g.lines = append(g.lines, r.Entry.Message)
+		g.size += len(r.Entry.Message) + 1
+		g.record.Line = append(append(g.record.Line, '\n'), r.Line...)
+		g.record.Truncated = g.record.Truncated || r.Truncated
+		g.last = now
+		if len(g.lines) >= j.maxLines || g.size >= j.maxBytes {
+			delete(j.pending, k)
+			return []*journald.Record{g.finish()}
+		}
+		return nil
+	}
+
+	var complete []*journald.Record
+	if g != nil {
+		complete = append(complete, g.finish())
+	}
+	j.sequence++
+	j.pending[k] = &group{
+		record:   r,
+		lines:    []string{r.Entry.Message},
+		size:     len(r.Entry.Message),
+		last:     now,
+		sequence: j.sequence,
+	}
+	return complete
+}
+
+// Flush returns the records to which no line was added for the timeout.
+func (j *Joiner) Flush(now time.Time) []*journald.Record {
+	var complete []*journald.Record
+	for _, k := range j.keys() {
+		if now.Sub(j.pending[k].last) >= j.timeout {
+			complete = append(complete, j.pending[k].finish())
+			delete(j.pending, k)
+		}
+	}
+	return complete
+}
+
+// FlushAll returns all the records being assembled.
+func (j *Joiner) FlushAll() []*journald.Record {
+	var complete []*journald.Record
+	for _, k := range j.keys() {
+		complete = append(complete, j.pending[k].finish())
+		delete(j.pending, k)
+	}
+	return complete
+}
+
+// keys returns the keys of the pending groups, in their creation order.
+func (j *Joiner) keys() []string {
+	keys := make([]string, 0, len(j.pending))
+	for k := range j.pending {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return j.pending[keys[a]].sequence < j.pending[keys[b]].sequence
+	})
+	return keys
+}
+
+// finish returns the record of the group, the first line being its message
+// and all the lines its full message.
+func (g *group) finish() *journald.Record {
+	if len(g.lines) > 1 {
+		g.record.FullMessage = strings.Join(g.lines, "\n")
+	}
+	return g.record
+}
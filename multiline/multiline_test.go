@@ -0,0 +1,116 @@
+package multiline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+func record(pid, message string) *journald.Record {
+	return &journald.Record{
+		Entry: journald.JournaldJSONLogEntry{SystemdUnit: "app.service", PID: pid, Message: message},
+		Line:  []byte(message),
+	}
+}
+
+func TestJoinStackTrace(t *testing.T) {
+	j, err := New("", `^\s`, time.Second, 100, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	for _, message := range []string{"java.lang.NullPointerException", "\tat Foo.bar(Foo.java:1)", "\tat Foo.main(Foo.java:2)"} {
+		if complete := j.Add(record("1", message), now); len(complete) != 0 {
+			t.Fatalf("nothing should be complete yet, got %d records", len(complete))
+		}
+	}
+
+	complete := j.Add(record("1", "next message"), now)
+	if len(complete) != 1 {
+		t.Fatalf("the stack trace should be complete, got %d records", len(complete))
+	}
+	r := complete[0]
+	if r.Entry.Message != "java.lang.NullPointerException" {
+		t.Errorf("unexpected message %q", r.Entry.Message)
+	}
+	if r.FullMessage != "java.lang.NullPointerException\n\tat Foo.bar(Foo.java:1)\n\tat Foo.main(Foo.java:2)" {
+		t.Errorf("unexpected full message %q", r.FullMessage)
+	}
+
+	complete = j.FlushAll()
+	if len(complete) != 1 || complete[0].Entry.Message != "next message" || complete[0].FullMessage != "" {
+		t.Errorf("unexpected remaining records %v", complete)
+	}
+}
+
+func TestStartRegexAndProcesses(t *testing.T) {
+	j, _ := New(`^\d{4}-`, "", time.Second, 100, 1000)
+	now := time.Now()
+
+	j.Add(record("1", "2017-01-01 Traceback (most recent call last):"), now)
+	j.Add(record("2", "2017-01-01 another process"), now)
+	j.Add(record("1", `  File "app.py", line 1`), now)
+	j.Add(record("1", "ValueError: boom"), now)
+
+	complete := j.FlushAll()
+	if len(complete) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(complete))
+	}
+	if complete[0].FullMessage != "2017-01-01 Traceback (most recent call last):\n  File \"app.py\", line 1\nValueError: boom" {
+		t.Errorf("unexpected full message %q", complete[0].FullMessage)
+	}
+}
+
+func TestContainers(t *testing.T) {
+	j, _ := New("", `^\s`, time.Second, 100, 1000)
+	now := time.Now()
+
+	// The Docker daemon logs the entries of all the containers.
+	containerRecord := func(container, message string) *journald.Record {
+		r := record("1", message)
+		r.Entry.SystemdUnit = "docker.service"
+		r.Entry.ContainerID = container
+		return r
+	}
+	j.Add(containerRecord("0123456789ab", "java.lang.NullPointerException"), now)
+	j.Add(containerRecord("ba9876543210", "java.lang.IllegalStateException"), now)
+	j.Add(containerRecord("0123456789ab", "\tat Foo.bar(Foo.java:1)"), now)
+	j.Add(containerRecord("ba9876543210", "\tat Bar.foo(Bar.java:2)"), now)
+
+	complete := j.FlushAll()
+	if len(complete) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(complete))
+	}
+	if complete[0].FullMessage != "java.lang.NullPointerException\n\tat Foo.bar(Foo.java:1)" {
+		t.Errorf("unexpected full message %q", complete[0].FullMessage)
+	}
+	if complete[1].FullMessage != "java.lang.IllegalStateException\n\tat Bar.foo(Bar.java:2)" {
+		t.Errorf("unexpected full message %q", complete[1].FullMessage)
+	}
+}
+
+func TestFlushTimeoutAndLimits(t *testing.T) {
+	j, _ := New("", `^\s`, time.Second, 2, 1000)
+	now := time.Now()
+
+	j.Add(record("1", "first"), now)
+	if complete := j.Flush(now.Add(500 * time.Millisecond)); len(complete) != 0 {
+		t.Error("the record should wait for the flush timeout")
+	}
+	if complete := j.Flush(now.Add(time.Second)); len(complete) != 1 {
+		t.Error("the record should be flushed after the timeout")
+	}
+
+	j.Add(record("1", "first"), now)
+	if complete := j.Add(record("1", " second"), now); len(complete) != 1 {
+		t.Error("the record should be complete once it reaches the maximum number of lines")
+	}
+}
+
+func TestNewRequiresARegex(t *testing.T) {
+	if _, err := New("", "", time.Second, 1, 1); err == nil {
+		t.Error("a regex should be required")
+	}
+}
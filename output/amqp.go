@@ -0,0 +1,198 @@
+package output
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/cdemers/journald2graylog/config"
+)
+
+const amqpTimeout = 10 * time.Second
+
+// amqpSession is a connection to an AMQP broker along with its channel, in
+// confirm mode.
+type amqpSession interface {
+	Publish(exchange, key string, msg amqp.Publishing) error
+	// Confirms returns the confirmations of the messages published, in
+	// order, it is closed along with the channel.
+	Confirms() <-chan amqp.Confirmation
+	Close() error
+}
+
+// dialAMQP is replaced by a fake broker in the tests.
+var dialAMQP = func(url string, t *tls.Config, exchange string, exchangeType string, confirms int) (amqpSession, error) {
+	conn, err := amqp.DialConfig(url, amqp.Config{Heartbeat: amqpTimeout, TLSClientConfig: t})
+	if err != nil {
+		return nil, err
+	}
+	s := &brokerSession{conn: conn}
+	s.channel, err = conn.Channel()
+	if err == nil && exchangeType != "" {
+		err = s.channel.ExchangeDeclare(exchange, exchangeType, true, false, false, false, nil)
+	}
+	if err == nil {
+		err = s.channel.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.confirms = s.channel.NotifyPublish(make(chan amqp.Confirmation, confirms))
+	return s, nil
+}
+
+// brokerSession is an amqpSession to an actual broker.
+type brokerSession struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func (s *brokerSession) Publish(exchange, key string, msg amqp.Publishing) error {
+	return s.channel.Publish(exchange, key, false, false, msg)
+}
+
+func (s *brokerSession) Confirms() <-chan amqp.Confirmation {
+	return s.confirms
+}
+
+func (s *brokerSession) Close() error {
+	return s.conn.Close()
+}
+
+// gelfAMQP publishes the GELF payloads to an AMQP exchange, in batches, as
+// persistent messages confirmed by the broker. The messages of a batch that
+// are not confirmed are published again, on a new connection if it was
+// lost.
+type gelfAMQP struct {
+	name         string
+	url          string
+	tls          *tls.Config
+	exchange     string
+	exchangeType string
+	routingKey   string
+	batchSize    int
+	maxRetries   int
+	retryBackoff time.Duration
+	batcher      *batcher
+
+	session amqpSession
+	// published is the number of messages published in the session, the
+	// delivery tag of the last one.
+	published uint64
+}
+
+func newGELFAMQP(d config.Destination) (*gelfAMQP, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return nil, err
+	}
+	if d.Username != "" {
+		u.User = url.UserPassword(d.Username, d.Password)
+	}
+	o := &gelfAMQP{
+		name:         d.Name,
+		url:          u.String(),
+		exchange:     d.AMQP.Exchange,
+		exchangeType: d.AMQP.ExchangeType,
+		routingKey:   d.AMQP.RoutingKey,
+		batchSize:    d.Batch.Size,
+		maxRetries:   d.Batch.MaxRetries,
+		retryBackoff: d.Batch.RetryBackoff,
+	}
+	if u.Scheme == "amqps" {
+		o.tls, err = newTLSConfig(d.TLS, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	}
+	o.batcher = newBatcher(d.Batch, o.publish)
+	return o, nil
+}
+
+func (o *gelfAMQP) Name() string {
+	return o.name
+}
+
+// Send adds the message to the batch, which is published once full or after
+// the batch wait.
+func (o *gelfAMQP) Send(m *Message) error {
+	return o.batcher.add(m)
+}
+
+// Close publishes the messages waiting in the batch and closes the
+// connection.
+func (o *gelfAMQP) Close() error {
+	err := o.batcher.close()
+	if o.session != nil {
+		if cerr := o.session.Close(); err == nil {
+			err = cerr
+		}
+		o.session = nil
+	}
+	return err
+}
+
+// publish publishes a batch of messages and waits for their confirmation.
+func (o *gelfAMQP) publish(batch []*Message) error {
+	pending := batch
+	return withRetries(o.maxRetries, o.retryBackoff, func() error {
+		if o.session == nil {
+			session, err := dialAMQP(o.url, o.tls, o.exchange, o.exchangeType, o.batchSize)
+			if err != nil {
+				return retryableError{err}
+			}
+			o.session, o.published = session, 0
+		}
+
+		first := o.published + 1
+		for _, m := range pending {
+			err := o.session.Publish(o.exchange, o.routingKey, amqp.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp.Persistent,
+				Timestamp:    m.Time,
+				Body:         m.Payload,
+			})
+			if err != nil {
+				o.disconnect()
+				return retryableError{err}
+			}
+			o.published++
+		}
+
+		var nacked []*Message
+		timeout := time.NewTimer(amqpTimeout)
+		defer timeout.Stop()
+		for range pending {
+			select {
+			case c, ok := <-o.session.Confirms():
+				if !ok {
+					o.disconnect()
+					return retryableError{fmt.Errorf("the channel was closed before the messages were confirmed")}
+				}
+				if !c.Ack {
+					nacked = append(nacked, pending[c.DeliveryTag-first])
+				}
+			case <-timeout.C:
+				o.disconnect()
+				return retryableError{fmt.Errorf("the messages were not confirmed within %s", amqpTimeout)}
+			}
+		}
+		pending = nacked
+		if len(pending) > 0 {
+			return retryableError{fmt.Errorf("%d messages were rejected by the broker", len(pending))}
+		}
+		return nil
+	})
+}
+
+// disconnect drops the session, a new one is established by the next
+// attempt.
+func (o *gelfAMQP) disconnect() {
+	o.session.Close()
+	o.session = nil
+}
@@ -0,0 +1,147 @@
+package output
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+)
+
+// maxRetryBackoff caps the time waited between two attempts to send a batch.
+const maxRetryBackoff = 30 * time.Second
+
+// batcher accumulates the messages of an output, and flushes them when the
+// batch is full or when its oldest message waited long enough.
+type batcher struct {
+	size  int
+	bytes int
+	wait  time.Duration
+	flush func(batch []*Message) error
+
+	// mutex protects the batch, which is also flushed by the timer. It is
+	// released while a batch is sent, so that messages can be added in the
+	// meantime.
+	mutex      sync.Mutex
+	batch      []*Message
+	batchBytes int
+	timer      *time.Timer
+	// err is the error of the last flush triggered by the timer, it is
+	// reported by the next add.
+	err error
+	// The batches are sent one at a time, in the order they were taken:
+	// taken is the number of batches taken, sent the number of those sent,
+	// and turn is signaled whenever a batch was sent.
+	taken int
+	sent  int
+	turn  *sync.Cond
+}
+
+func newBatcher(b config.Batch, flush func(batch []*Message) error) *batcher {
+	o := &batcher{
+		size:  b.Size,
+		bytes: b.Bytes,
+		wait:  b.Wait,
+		flush: flush,
+	}
+	o.turn = sync.NewCond(&o.mutex)
+	return o
+}
+
+// add adds a message to the batch, and flushes it if it is full. It returns
+// the error of the flush, or of the previous one if it was triggered by the
+// timer, the message being added nonetheless.
+func (b *batcher) add(m *Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := b.err
+	b.err = nil
+
+	b.batch = append(b.batch, m)
+	b.batchBytes += len(m.Payload)
+	if len(b.batch) >= b.size || b.batchBytes >= b.bytes {
+		if ferr := b.flushLocked(); ferr != nil {
+			err = ferr
+		}
+		return err
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.wait, b.expire)
+	}
+	return err
+}
+
+// expire flushes the batch once its oldest message waited long enough.
+func (b *batcher) expire() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.timer = nil
+	if len(b.batch) > 0 {
+		if err := b.flushLocked(); err != nil {
+			b.err = err
+		}
+	}
+}
+
+// close flushes the messages waiting in the batch, once the batches taken
+// before were sent. It returns the error of the flush, or of the previous
+// one if it was triggered by the timer.
+func (b *batcher) close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err := b.flushLocked()
+	if err == nil {
+		err = b.err
+	}
+	b.err = nil
+	return err
+}
+
+// flushLocked takes the batch and sends it once the batches taken before
+// were sent, releasing the lock in the meantime. The batch is dropped if it
+// could not be sent.
+func (b *batcher) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.batch
+	b.batch, b.batchBytes = nil, 0
+	ticket := b.taken
+	b.taken++
+	for b.sent != ticket {
+		b.turn.Wait()
+	}
+
+	var err error
+	if len(batch) > 0 {
+		b.mutex.Unlock()
+		err = b.flush(batch)
+		b.mutex.Lock()
+	}
+	b.sent++
+	b.turn.Broadcast()
+	return err
+}
+
+// retryableError is an error after which sending again may succeed, such as
+// a network error or an overloaded server.
+type retryableError struct {
+	error
+}
+
+// withRetries calls send until it succeeds, fails with an error that is not
+// retryable, or was retried maxRetries times, waiting backoff, doubled at
+// every retry, in between.
+func withRetries(maxRetries int, backoff time.Duration, send func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if _, ok := err.(retryableError); !ok || attempt >= maxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package output
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+)
+
+func TestBatcherTimerFlush(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var flushed [][]*Message
+	b := newBatcher(config.Batch{Size: 2, Bytes: 1 << 20, Wait: 10 * time.Millisecond}, func(batch []*Message) error {
+		flushed = append(flushed, batch)
+		if len(flushed) == 1 {
+			close(started)
+			<-release
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err := b.add(testLokiMessage("ssh.service", "6", "first", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The messages are added while the batch flushed by the timer is sent,
+	// the next batch being only flushed once full.
+	<-started
+	b.mutex.Lock()
+	b.wait = time.Hour
+	b.mutex.Unlock()
+	added := make(chan error)
+	go func() {
+		added <- b.add(testLokiMessage("ssh.service", "6", "second", 2))
+	}()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding a message was blocked by the flush")
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mutex.Lock()
+		failed := b.err != nil
+		b.mutex.Unlock()
+		if failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the flush triggered by the timer did not fail")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The failure is reported by the next message, which is kept.
+	third := testLokiMessage("ssh.service", "6", "third", 3)
+	if err := b.add(third); err == nil {
+		t.Error("the failure of the previous flush should be reported")
+	}
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) != 2 || len(flushed[1]) != 2 || flushed[1][1] != third {
+		t.Errorf("unexpected batches %v", flushed)
+	}
+}
@@ -0,0 +1,260 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+// elasticsearch indexes the entries in Elasticsearch or OpenSearch, in
+// batches sent to the bulk API.
+type elasticsearch struct {
+	name         string
+	url          string
+	sender       *httpSender
+	header       http.Header
+	index        *template.Template
+	action       string
+	maxRetries   int
+	retryBackoff time.Duration
+	batcher      *batcher
+}
+
+func newElasticsearch(d config.Destination) (*elasticsearch, error) {
+	sender, err := newHTTPSender(d)
+	if err != nil {
+		return nil, err
+	}
+	index, err := template.New("index").Parse(d.Elasticsearch.Index)
+	if err != nil {
+		return nil, err
+	}
+	bulkURL := strings.TrimSuffix(d.URL, "/") + "/_bulk"
+	if d.Elasticsearch.Pipeline != "" {
+		bulkURL += "?pipeline=" + url.QueryEscape(d.Elasticsearch.Pipeline)
+	}
+	o := &elasticsearch{
+		name:         d.Name,
+		url:          bulkURL,
+		sender:       sender,
+		header:       http.Header{"Content-Type": {"application/x-ndjson"}},
+		index:        index,
+		action:       d.Elasticsearch.Action,
+		maxRetries:   d.Batch.MaxRetries,
+		retryBackoff: d.Batch.RetryBackoff,
+	}
+	o.batcher = newBatcher(d.Batch, o.bulk)
+	return o, nil
+}
+
+func (o *elasticsearch) Name() string {
+	return o.name
+}
+
+// Send adds the message to the batch, which is sent once full or after the
+// batch wait.
+func (o *elasticsearch) Send(m *Message) error {
+	return o.batcher.add(m)
+}
+
+// Close sends the messages waiting in the batch.
+func (o *elasticsearch) Close() error {
+	return o.batcher.close()
+}
+
+// bulkDocument is a document of a bulk request, its action line followed by
+// its source.
+type bulkDocument struct {
+	action []byte
+	source []byte
+}
+
+// bulkItem is the outcome of the action on a single document.
+type bulkItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// bulk sends a batch of messages to the bulk API. The documents that could
+// not be indexed for the time being are sent again, the others are counted
+// as rejected and logged, as sending them again would fail the same way, as
+// are those still failing once the retries are exhausted.
+func (o *elasticsearch) bulk(batch []*Message) error {
+	pending := make([]bulkDocument, len(batch))
+	for i, m := range batch {
+		doc, err := o.document(m)
+		if err != nil {
+			return err
+		}
+		pending[i] = doc
+	}
+
+	var rejected int
+	var reason string
+	// itemsErr is the failure of the documents of the last attempt, as
+	// opposed to the failure of the whole request.
+	var itemsErr error
+	err := withRetries(o.maxRetries, o.retryBackoff, func() error {
+		var body bytes.Buffer
+		for _, doc := range pending {
+			body.Write(doc.action)
+			body.WriteByte('\n')
+			body.Write(doc.source)
+			body.WriteByte('\n')
+		}
+		response, err := o.sender.post(o.url, o.header, body.Bytes())
+		if err != nil {
+			return err
+		}
+
+		outcome, err := checkBulkResponse(pending, response)
+		if err != nil {
+			return err
+		}
+		if outcome.rejected > 0 && rejected == 0 {
+			reason = outcome.rejectedFailure
+		}
+		rejected += outcome.rejected
+		pending = outcome.retry
+		if len(pending) > 0 {
+			itemsErr = retryableError{fmt.Errorf("%d documents could not be indexed: %s", len(pending), outcome.retryFailure)}
+			return itemsErr
+		}
+		return nil
+	})
+	if rejected > 0 {
+		metrics.RejectedDocuments.Add(float64(rejected), o.name)
+		logging.WithFields(logging.Fields{"output": o.name, "rejected": rejected, "error": reason}).Warnf("Documents were rejected by the bulk API.")
+	}
+	if err != nil && err == itemsErr {
+		metrics.RejectedDocuments.Add(float64(len(pending)), o.name)
+		logging.WithFields(logging.Fields{"output": o.name, "rejected": len(pending), "error": err}).Warnf("Documents could not be indexed once the retries were exhausted.")
+		return nil
+	}
+	return err
+}
+
+// document builds the bulk document of a message, its GELF payload with the
+// @timestamp field the time based features of Kibana and OpenSearch
+// Dashboards rely on.
+func (o *elasticsearch) document(m *Message) (bulkDocument, error) {
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+	var index bytes.Buffer
+	data := struct {
+		Date string
+		Time time.Time
+		Host string
+	}{t.Format("2006.01.02"), t, m.Host}
+	if err := o.index.Execute(&index, data); err != nil {
+		return bulkDocument{}, err
+	}
+
+	action, err := json.Marshal(map[string]map[string]string{o.action: {"_index": index.String()}})
+	if err != nil {
+		return bulkDocument{}, err
+	}
+	source, err := json.Marshal(documentFields(m.Payload, t))
+	if err != nil {
+		return bulkDocument{}, err
+	}
+	return bulkDocument{action: action, source: source}, nil
+}
+
+// documentFields returns the fields of the document of a GELF payload. The
+// leading underscores of the additional fields are removed, as Graylog does
+// when it indexes them, lest fields such as _type or _source clash with the
+// metadata fields of the documents. The standard fields are kept over the
+// additional fields of the same name.
+func documentFields(payload []byte, t time.Time) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		fields = nil
+	}
+	document := map[string]json.RawMessage{}
+	for name, value := range fields {
+		if !strings.HasPrefix(name, "_") {
+			document[name] = value
+		}
+	}
+	for name, value := range fields {
+		name = strings.TrimLeft(name, "_")
+		if _, ok := document[name]; !ok && name != "" {
+			document[name] = value
+		}
+	}
+	document["@timestamp"], _ = json.Marshal(t.Format(time.RFC3339Nano))
+	return document
+}
+
+// bulkOutcome sorts the documents of a bulk request that failed: those to
+// send again, which failed with a 429 Too Many Requests or a 5xx status, and
+// those rejected for good, along with the first failure of each.
+type bulkOutcome struct {
+	retry           []bulkDocument
+	retryFailure    string
+	rejected        int
+	rejectedFailure string
+}
+
+// checkBulkResponse returns the outcome of a bulk request from its response.
+func checkBulkResponse(docs []bulkDocument, response []byte) (bulkOutcome, error) {
+	var r struct {
+		Errors bool                  `json:"errors"`
+		Items  []map[string]bulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(response, &r); err != nil {
+		return bulkOutcome{}, fmt.Errorf("invalid bulk response: %s", err)
+	}
+	if !r.Errors {
+		return bulkOutcome{}, nil
+	}
+	if len(r.Items) != len(docs) {
+		return bulkOutcome{}, fmt.Errorf("the bulk response has %d items for %d documents", len(r.Items), len(docs))
+	}
+
+	var outcome bulkOutcome
+	for i, actions := range r.Items {
+		for _, item := range actions {
+			switch {
+			case item.Status/100 == 2:
+			case item.Status == http.StatusTooManyRequests || item.Status/100 == 5:
+				if outcome.retry == nil {
+					outcome.retryFailure = bulkError(item)
+				}
+				outcome.retry = append(outcome.retry, docs[i])
+			default:
+				if outcome.rejected == 0 {
+					outcome.rejectedFailure = bulkError(item)
+				}
+				outcome.rejected++
+			}
+		}
+	}
+	return outcome, nil
+}
+
+// bulkError describes the failure of a bulk item, such as
+// "400 mapper_parsing_exception: failed to parse field [level]".
+func bulkError(item bulkItem) string {
+	var e struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if json.Unmarshal(item.Error, &e) != nil || e.Type == "" {
+		return fmt.Sprintf("%d %s", item.Status, item.Error)
+	}
+	return fmt.Sprintf("%d %s: %s", item.Status, e.Type, e.Reason)
+}
@@ -0,0 +1,207 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/logging"
+)
+
+// bulkStandIn is a local stand-in for the bulk API, it records the bodies of
+// the requests it receives and answers with the given responses, then with
+// the success of all the documents.
+type bulkStandIn struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	responses []string
+	requests  []*http.Request
+	bodies    [][]byte
+}
+
+func newBulkStandIn(responses ...string) *bulkStandIn {
+	s := &bulkStandIn{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if len(s.responses) > 0 {
+			var response string
+			response, s.responses = s.responses[0], s.responses[1:]
+			w.Write([]byte(response))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	return s
+}
+
+func (s *bulkStandIn) received() ([]*http.Request, [][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.bodies
+}
+
+func testElasticsearchDestination(url string) config.Destination {
+	return config.Destination{
+		Name:     "opensearch",
+		Type:     config.TypeElasticsearch,
+		URL:      url + "/",
+		Username: "journald",
+		Password: "secret",
+		Elasticsearch: config.Elasticsearch{
+			Index:    "journald-{{.Date}}",
+			Action:   config.ElasticsearchCreate,
+			Pipeline: "gelf",
+		},
+		Batch: config.Batch{Size: 2, Bytes: 1 << 20, Wait: time.Hour, MaxRetries: 2, RetryBackoff: time.Millisecond},
+	}
+}
+
+// bulkLines returns the lines of a bulk request body, decoded.
+func bulkLines(t *testing.T, body []byte) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSuffix(body, []byte("\n")), []byte("\n")) {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			t.Fatalf("invalid line %s: %s", line, err)
+		}
+		lines = append(lines, decoded)
+	}
+	return lines
+}
+
+func TestElasticsearchBulk(t *testing.T) {
+	server := newBulkStandIn()
+	defer server.Close()
+	o, err := newElasticsearch(testElasticsearchDestination(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := testLokiMessage("ssh.service", "6", "first", 1)
+	first.Payload = []byte(`{"short_message":"first","host":"node-1","_type":"audit","_PID":1234,"_host":"other"}`)
+	second := testLokiMessage("ssh.service", "6", "second", 2)
+	second.Time = time.Date(2018, 3, 15, 1, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	for _, m := range []*Message{first, second} {
+		if err := o.Send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests, bodies := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected a single bulk request, got %d", len(requests))
+	}
+	r := requests[0]
+	if r.URL.Path != "/_bulk" || r.URL.Query().Get("pipeline") != "gelf" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("unexpected request %s %s", r.URL, r.Header)
+	}
+	if username, password, ok := r.BasicAuth(); !ok || username != "journald" || password != "secret" {
+		t.Error("the request should be authenticated")
+	}
+
+	lines := bulkLines(t, bodies[0])
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %s", bodies[0])
+	}
+	// The indices are named after the UTC day of the entries.
+	for i, index := range []string{"journald-2018.03.14", "journald-2018.03.15"} {
+		action, ok := lines[2*i]["create"].(map[string]interface{})
+		if !ok || action["_index"] != index {
+			t.Errorf("got action %v, expected the creation of a document in %s", lines[2*i], index)
+		}
+	}
+	if lines[1]["short_message"] != "first" || lines[1]["@timestamp"] != "2018-03-14T15:09:01Z" {
+		t.Errorf("unexpected document %v", lines[1])
+	}
+	// The additional fields are indexed without their underscore, so that
+	// they do not clash with the metadata fields.
+	if lines[1]["type"] != "audit" || lines[1]["PID"] != 1234.0 || lines[1]["host"] != "node-1" || lines[1]["_type"] != nil {
+		t.Errorf("unexpected additional fields %v", lines[1])
+	}
+	if lines[3]["@timestamp"] != "2018-03-15T06:00:00Z" {
+		t.Errorf("unexpected document %v", lines[3])
+	}
+}
+
+func TestElasticsearchItemErrors(t *testing.T) {
+	server := newBulkStandIn(
+		`{"errors":true,"items":[
+			{"create":{"status":201}},
+			{"create":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}},
+			{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [level]"}}}
+		]}`,
+	)
+	defer server.Close()
+	d := testElasticsearchDestination(server.URL)
+	d.Batch.Size = 3
+	o, err := newElasticsearch(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []logging.Record
+	logging.SetHook(func(r logging.Record) { warnings = append(warnings, r) })
+	defer logging.SetHook(nil)
+	for i, message := range []string{"indexed", "retried", "rejected"} {
+		if err := o.Send(testLokiMessage("ssh.service", "6", message, i)); err != nil {
+			t.Fatalf("a rejected document should not fail the batch: %s", err)
+		}
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(fmt.Sprint(warnings[0].Fields["error"]), "400 mapper_parsing_exception") {
+		t.Errorf("the rejected document should be logged, got %v", warnings)
+	}
+
+	// Only the document that failed with a 429 is sent again.
+	_, bodies := server.received()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 bulk requests, got %d", len(bodies))
+	}
+	lines := bulkLines(t, bodies[1])
+	if len(lines) != 2 || lines[1]["short_message"] != "retried" {
+		t.Errorf("unexpected retry %s", bodies[1])
+	}
+}
+
+func TestElasticsearchRetriesExhausted(t *testing.T) {
+	busy := `{"errors":true,"items":[{"index":{"status":503,"error":{"type":"unavailable_shards_exception","reason":"primary shard is not active"}}}]}`
+	server := newBulkStandIn(busy, busy, busy)
+	defer server.Close()
+	d := testElasticsearchDestination(server.URL)
+	d.Batch.Size = 1
+	o, err := newElasticsearch(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The documents still failing once the retries are exhausted are
+	// logged as the rejected ones.
+	var warnings []logging.Record
+	logging.SetHook(func(r logging.Record) { warnings = append(warnings, r) })
+	defer logging.SetHook(nil)
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 1)); err != nil {
+		t.Errorf("the documents failing once the retries are exhausted should not fail the batch: %s", err)
+	}
+	if requests, _ := server.received(); len(requests) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(requests))
+	}
+	if len(warnings) != 1 || warnings[0].Fields["rejected"] != 1 || !strings.Contains(fmt.Sprint(warnings[0].Fields["error"]), "503 unavailable_shards_exception") {
+		t.Errorf("the documents that could not be indexed should be logged, got %v", warnings)
+	}
+
+	// The requests failing as a whole still fail the batch.
+	server.Close()
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 2)); err == nil {
+		t.Error("the batch should fail when the server cannot be reached")
+	}
+}
@@ -0,0 +1,323 @@
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+// rotatedLayout is the layout of the time the rotated files are suffixed
+// with, in UTC, such as gelf.json.2018-03-14T15-09-26.535.
+const rotatedLayout = "2006-01-02T15-04-05.000"
+
+// file writes the GELF payloads to a file, one per line, and rotates it once
+// it is big or old enough.
+type file struct {
+	name           string
+	path           string
+	maxSize        int64
+	rotateInterval time.Duration
+	compress       bool
+	keep           int
+	sync           string
+	syncInterval   time.Duration
+	// rotated matches the names of the rotated files, capturing their
+	// time and their counter.
+	rotated *regexp.Regexp
+	// now is replaced in the tests.
+	now func() time.Time
+	// rotations receives the paths of the files rotated, which are
+	// compressed and pruned in the background, so that compressing a big
+	// file does not hold the messages back. done is closed once they all
+	// were.
+	rotations chan string
+	done      chan struct{}
+
+	// mutex protects the file, which is also synced by the timer.
+	mutex    sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+	timer    *time.Timer
+	// err is the error of the last sync triggered by the timer, it is
+	// logged by the next message.
+	err error
+}
+
+// newFile returns a file output, the file is opened right away so that a
+// path that cannot be written to is reported on startup.
+func newFile(d config.Destination) (*file, error) {
+	o := &file{
+		name:           d.Name,
+		path:           d.File.Path,
+		maxSize:        d.File.MaxSize,
+		rotateInterval: d.File.RotateInterval,
+		compress:       d.File.Compress,
+		keep:           d.File.Keep,
+		sync:           d.File.Sync,
+		syncInterval:   d.File.SyncInterval,
+		rotated: regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(d.File.Path)) +
+			`\.(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{3})(?:-(\d+))?(?:\.gz)?$`),
+		now:       time.Now,
+		rotations: make(chan string, 16),
+		done:      make(chan struct{}),
+	}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	go o.archive()
+	return o, nil
+}
+
+func (o *file) Name() string {
+	return o.name
+}
+
+// Send appends the payload of the message to the file, after rotating it if
+// needed. The failure of the previous sync is logged, it is not the failure
+// of this message.
+func (o *file) Send(m *Message) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if err := o.err; err != nil {
+		o.err = nil
+		metrics.SendErrors.Inc(o.name)
+		logging.WithFields(logging.Fields{"output": o.name, "path": o.path, "error": err}).Warnf("Could not sync the file.")
+	}
+
+	line := make([]byte, 0, len(m.Payload)+1)
+	line = append(append(line, m.Payload...), '\n')
+	if o.f != nil && o.due(len(line)) {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+	}
+	if o.f == nil {
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := o.f.Write(line)
+	o.size += int64(n)
+	if err != nil {
+		return err
+	}
+	switch o.sync {
+	case config.FileSyncAlways:
+		return o.f.Sync()
+	case config.FileSyncInterval:
+		if o.timer == nil {
+			o.timer = time.AfterFunc(o.syncInterval, o.syncLater)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the file, once the files rotated are compressed
+// and pruned.
+func (o *file) Close() error {
+	o.mutex.Lock()
+	err := o.closeLocked()
+	o.mutex.Unlock()
+	close(o.rotations)
+	<-o.done
+	return err
+}
+
+// due returns true if the file must be rotated before n more bytes are
+// written to it.
+func (o *file) due(n int) bool {
+	if o.maxSize > 0 && o.size > 0 && o.size+int64(n) > o.maxSize {
+		return true
+	}
+	return o.rotateInterval > 0 && o.now().Sub(o.openedAt) >= o.rotateInterval
+}
+
+func (o *file) open() error {
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// A file written before, by a previous run, is as old as its last
+	// entry, so that restarting does not postpone its rotation.
+	o.f, o.size, o.openedAt = f, info.Size(), o.now()
+	if info.Size() > 0 {
+		o.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// syncLater flushes the file once the sync interval elapsed since an entry
+// was written.
+func (o *file) syncLater() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.timer = nil
+	if o.f != nil {
+		o.err = o.f.Sync()
+	}
+}
+
+func (o *file) closeLocked() error {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if o.f == nil {
+		return nil
+	}
+	var err error
+	if o.sync != config.FileSyncNone {
+		err = o.f.Sync()
+	}
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	o.f = nil
+	return err
+}
+
+// rotate renames the file after the time it is rotated at, followed by a
+// counter if a file was already rotated at that time, and hands it over to
+// the background goroutine. The file is opened again by the next message.
+func (o *file) rotate() error {
+	if err := o.closeLocked(); err != nil {
+		return err
+	}
+	stamp := o.now().UTC().Format(rotatedLayout)
+	rotated := o.path + "." + stamp
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%s-%d", o.path, stamp, i)
+	}
+	if err := os.Rename(o.path, rotated); err != nil {
+		return err
+	}
+	o.rotations <- rotated
+	return nil
+}
+
+// archive compresses the files rotated if required, and removes the oldest
+// rotated files beyond those to keep. The failures are logged, as the
+// messages are written to the new file nonetheless.
+func (o *file) archive() {
+	defer close(o.done)
+	for rotated := range o.rotations {
+		if o.compress {
+			if err := gzipFile(rotated); err != nil {
+				logging.WithFields(logging.Fields{"output": o.name, "path": rotated, "error": err}).Warnf("Could not compress the rotated file.")
+			}
+		}
+		if o.keep > 0 {
+			if err := o.prune(); err != nil {
+				logging.WithFields(logging.Fields{"output": o.name, "path": o.path, "error": err}).Warnf("Could not remove the oldest rotated files.")
+			}
+		}
+	}
+}
+
+// prune removes the oldest rotated files beyond those to keep.
+func (o *file) prune() error {
+	infos, err := ioutil.ReadDir(filepath.Dir(o.path))
+	if err != nil {
+		return err
+	}
+	var names []string
+	var matches [][]string
+	for _, info := range infos {
+		if match := o.rotated.FindStringSubmatch(info.Name()); match != nil {
+			names = append(names, info.Name())
+			matches = append(matches, match)
+		}
+	}
+	sort.Sort(byRotation{names, matches})
+	for len(names) > o.keep {
+		if err := os.Remove(filepath.Join(filepath.Dir(o.path), names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// gzipFile compresses a file to the same path with the .gz extension, and
+// removes it once its compressed copy is on the disk.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(out)
+	_, err = io.Copy(w, in)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// byRotation sorts the rotated files from the oldest to the most recent,
+// by their time and then their counter.
+type byRotation struct {
+	names   []string
+	matches [][]string
+}
+
+func (r byRotation) Len() int {
+	return len(r.names)
+}
+
+func (r byRotation) Less(i, j int) bool {
+	if r.matches[i][1] != r.matches[j][1] {
+		return r.matches[i][1] < r.matches[j][1]
+	}
+	counter := func(k int) int {
+		n, _ := strconv.Atoi(r.matches[k][2])
+		return n
+	}
+	return counter(i) < counter(j)
+}
+
+func (r byRotation) Swap(i, j int) {
+	r.names[i], r.names[j] = r.names[j], r.names[i]
+	r.matches[i], r.matches[j] = r.matches[j], r.matches[i]
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,203 @@
+package output
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+)
+
+func testFileDestination(dir string) config.Destination {
+	return config.Destination{
+		Name: "archive",
+		Type: config.TypeFile,
+		File: config.File{
+			Path: filepath.Join(dir, "archive", "gelf.json"),
+			Sync: config.FileSyncAlways,
+		},
+	}
+}
+
+// newTestFile returns a file output reading the time from now, which is
+// set to 2018-03-14T15:09:26Z.
+func newTestFile(t *testing.T, d config.Destination, now *time.Time) *file {
+	*now = time.Date(2018, 3, 14, 15, 9, 26, 0, time.UTC)
+	o, err := newFile(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.now = func() time.Time { return *now }
+	o.openedAt = *now
+	return o
+}
+
+// rotatedFiles returns the names of the files of a directory, but the
+// current one.
+func rotatedFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Name() != "gelf.json" {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileRotateSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald2graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := testFileDestination(dir)
+	// Room for two messages per file.
+	d.File.MaxSize = 60
+	d.File.Compress = true
+	d.File.Keep = 2
+	var now time.Time
+	o := newTestFile(t, d, &now)
+
+	for _, message := range []string{"one", "two", "three", "four", "five", "six", "seven"} {
+		now = now.Add(time.Second)
+		if err := o.Send(testLokiMessage("ssh.service", "6", message, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := ioutil.ReadFile(d.File.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "{\"short_message\":\"seven\"}\n" {
+		t.Errorf("unexpected current file %q", current)
+	}
+	// The three files rotated are compressed, and only the last two are
+	// kept.
+	rotated := rotatedFiles(t, filepath.Dir(d.File.Path))
+	expected := []string{"gelf.json.2018-03-14T15-09-31.000.gz", "gelf.json.2018-03-14T15-09-33.000.gz"}
+	if len(rotated) != 2 || rotated[0] != expected[0] || rotated[1] != expected[1] {
+		t.Fatalf("got rotated files %v, expected %v", rotated, expected)
+	}
+	f, err := os.Open(filepath.Join(filepath.Dir(d.File.Path), rotated[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "{\"short_message\":\"five\"}\n{\"short_message\":\"six\"}\n" {
+		t.Errorf("unexpected rotated file %q", content)
+	}
+}
+
+func TestFileRotateInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald2graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := testFileDestination(dir)
+	d.File.RotateInterval = time.Hour
+	d.File.Keep = 1
+	d.File.Sync = config.FileSyncInterval
+	d.File.SyncInterval = time.Millisecond
+	os.MkdirAll(filepath.Dir(d.File.Path), 0755)
+	if err := ioutil.WriteFile(d.File.Path, []byte("{\"short_message\":\"before\"}\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file written before is appended to.
+	var now time.Time
+	o := newTestFile(t, d, &now)
+	if err := o.Send(testLokiMessage("ssh.service", "6", "first", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := rotatedFiles(t, filepath.Dir(d.File.Path)); len(rotated) != 0 {
+		t.Errorf("the file should not be rotated yet, got %v", rotated)
+	}
+
+	// The files rotated at the same time are told apart by a counter, the
+	// last one being kept.
+	for _, message := range []string{"second", "third"} {
+		o.mutex.Lock()
+		o.openedAt = o.openedAt.Add(-time.Hour)
+		o.mutex.Unlock()
+		if err := o.Send(testLokiMessage("ssh.service", "6", message, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := rotatedFiles(t, filepath.Dir(d.File.Path))
+	if len(rotated) != 1 || rotated[0] != "gelf.json.2018-03-14T15-09-26.000-1" {
+		t.Fatalf("unexpected rotated files %v", rotated)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(filepath.Dir(d.File.Path), rotated[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "{\"short_message\":\"second\"}\n" {
+		t.Errorf("unexpected rotated file %q", content)
+	}
+}
+
+func TestFileRotatePreviousRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald2graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := testFileDestination(dir)
+	d.File.RotateInterval = time.Hour
+	os.MkdirAll(filepath.Dir(d.File.Path), 0755)
+	if err := ioutil.WriteFile(d.File.Path, []byte("{\"short_message\":\"before\"}\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(d.File.Path, written, written); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file written by a previous run is rotated by the first message,
+	// as its last entry is older than the rotate interval.
+	o, err := newFile(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Send(testLokiMessage("ssh.service", "6", "after", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := rotatedFiles(t, filepath.Dir(d.File.Path)); len(rotated) != 1 {
+		t.Errorf("expected the previous file to be rotated, got %v", rotated)
+	}
+	current, err := ioutil.ReadFile(d.File.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "{\"short_message\":\"after\"}\n" {
+		t.Errorf("unexpected current file %q", current)
+	}
+}
@@ -0,0 +1,189 @@
+package output
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/metrics"
+	rkgelf "github.com/robertkowalski/graylog-golang"
+)
+
+const (
+	gelfTCPTimeout = 10 * time.Second
+	gelfMaxChunks  = 128
+)
+
+// gelfUDP sends the GELF payloads to a Graylog UDP input, chunking them when
+// they are bigger than the packet size.
+type gelfUDP struct {
+	name    string
+	graylog *rkgelf.Gelf
+}
+
+func newGELFUDP(d config.Destination) *gelfUDP {
+	return &gelfUDP{
+		name: d.Name,
+		graylog: rkgelf.New(rkgelf.Config{
+			GraylogHostname: d.Hostname,
+			GraylogPort:     d.Port,
+			Connection:      "wan",
+			MaxChunkSizeWan: d.PacketSize,
+		}),
+	}
+}
+
+func (o *gelfUDP) Name() string {
+	return o.name
+}
+
+// Send compresses the payload and sends it in as many chunks as needed, it
+// is equivalent to rkgelf.Gelf.Log but keeps count of the chunks. The
+// payloads needing more chunks than GELF allows are truncated, or dropped if
+// they cannot be, as they would be discarded by Graylog anyway.
+func (o *gelfUDP) Send(m *Message) error {
+	compressed, ok := o.fit(m.Payload)
+	if !ok {
+		metrics.DroppedPayloads.Inc(o.name)
+		logging.WithFields(logging.Fields{"output": o.name, "size": len(m.Payload)}).Warnf("The payload does not fit in the chunks allowed by GELF, it will be skipped.")
+		return nil
+	}
+	chunkSize := o.graylog.GetChunksize()
+	length := compressed.Len()
+
+	if length <= chunkSize {
+		err := o.graylog.Send(compressed.Bytes())
+		if err != nil {
+			return err
+		}
+		metrics.ChunksSent.Inc(o.name)
+		return nil
+	}
+
+	chunkCount := int(math.Ceil(float64(length) / float64(chunkSize)))
+	id := make([]byte, 8)
+	rand.Read(id)
+	for index := 0; index < chunkCount; index++ {
+		packet := o.graylog.CreateChunkedMessage(index, chunkCount, id, &compressed)
+		err := o.graylog.Send(packet.Bytes())
+		if err != nil {
+			return err
+		}
+		metrics.ChunksSent.Inc(o.name)
+	}
+	return nil
+}
+
+// fit compresses the payload, once its raw log line is removed and its full
+// and short messages are truncated if it needs more chunks than GELF allows.
+// It returns false if the payload cannot fit.
+func (o *gelfUDP) fit(payload []byte) (bytes.Buffer, bool) {
+	limit := gelfMaxChunks * o.graylog.GetChunksize()
+	compressed := o.graylog.Compress(payload)
+	if compressed.Len() <= limit {
+		return compressed, true
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return bytes.Buffer{}, false
+	}
+	delete(fields, "_RawLogLine")
+	fields["_truncated"] = true
+	// The messages are shortened in proportion of the excess, with some
+	// margin as the compression ratio varies, until the payload fits.
+	for attempt := 0; attempt < 10; attempt++ {
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return bytes.Buffer{}, false
+		}
+		compressed = o.graylog.Compress(encoded)
+		if compressed.Len() <= limit {
+			metrics.TruncatedPayloads.Inc(o.name)
+			return compressed, true
+		}
+		ratio := 0.9 * float64(limit) / float64(compressed.Len())
+		if !shortenField(fields, "full_message", ratio) && !shortenField(fields, "short_message", ratio) {
+			break
+		}
+	}
+	return bytes.Buffer{}, false
+}
+
+// shortenField truncates a string field to the given ratio of its length, on
+// a character boundary. It returns false if the field cannot be shortened
+// without being emptied.
+func shortenField(fields map[string]interface{}, name string, ratio float64) bool {
+	value, _ := fields[name].(string)
+	n := int(float64(len(value)) * ratio)
+	for n > 0 && !utf8.RuneStart(value[n]) {
+		n--
+	}
+	if n == 0 {
+		return false
+	}
+	fields[name] = value[:n]
+	return true
+}
+
+func (o *gelfUDP) Close() error {
+	return nil
+}
+
+// gelfTCP sends the GELF payloads to a Graylog TCP input, each payload being
+// terminated by a null byte as required by the GELF TCP framing.
+type gelfTCP struct {
+	name    string
+	address string
+	conn    net.Conn
+}
+
+func newGELFTCP(d config.Destination) *gelfTCP {
+	return &gelfTCP{
+		name:    d.Name,
+		address: net.JoinHostPort(d.Hostname, fmt.Sprint(d.Port)),
+	}
+}
+
+func (o *gelfTCP) Name() string {
+	return o.name
+}
+
+func (o *gelfTCP) Send(m *Message) error {
+	if o.conn == nil {
+		conn, err := net.DialTimeout("tcp", o.address, gelfTCPTimeout)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+
+	frame := make([]byte, len(m.Payload)+1)
+	copy(frame, m.Payload)
+
+	o.conn.SetWriteDeadline(time.Now().Add(gelfTCPTimeout))
+	_, err := o.conn.Write(frame)
+	if err != nil {
+		// Drop the connection, it will be established again on the next
+		// message.
+		o.conn.Close()
+		o.conn = nil
+	}
+	return err
+}
+
+func (o *gelfTCP) Close() error {
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
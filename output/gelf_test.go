@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/gelf"
+)
+
+// receiveGELFUDP reads the chunks of a single GELF message from conn and
+// returns its decoded fields.
+func receiveGELFUDP(t *testing.T, conn net.PacketConn) (map[string]interface{}, int) {
+	var chunks [][]byte
+	buffer := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for received := 0; chunks == nil || received < len(chunks); received++ {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet := buffer[:n]
+		// The payloads sent in a single packet are not chunked.
+		if packet[0] != 0x1e || packet[1] != 0x0f {
+			return decodeGELF(t, packet), 1
+		}
+		if chunks == nil {
+			chunks = make([][]byte, packet[11])
+		}
+		chunks[packet[10]] = append([]byte(nil), packet[12:]...)
+	}
+	return decodeGELF(t, bytes.Join(chunks, nil)), len(chunks)
+}
+
+// decodeGELF decompresses and decodes a GELF payload.
+func decodeGELF(t *testing.T, compressed []byte) map[string]interface{} {
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+// randomText returns n characters that do not compress well.
+func randomText(n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	r := rand.New(rand.NewSource(42))
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(text)
+}
+
+func TestGELFUDPTruncate(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	o := newGELFUDP(config.Destination{Name: "graylog", Hostname: "127.0.0.1", Port: port, PacketSize: 100})
+
+	// The payload needs more than 128 chunks of 100 bytes once compressed.
+	full := randomText(gelfMaxChunks * 100 * 2)
+	payload, _ := json.Marshal(gelf.GELFLogEntry{
+		Version:      gelf.Version,
+		Host:         "node-1",
+		ShortMessage: "Exception in thread \"main\"",
+		FullMessage:  full,
+		RawLogLine:   full,
+	})
+	if err := o.Send(&Message{Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+
+	fields, chunks := receiveGELFUDP(t, conn)
+	if chunks > gelfMaxChunks {
+		t.Errorf("got %d chunks, more than %d", chunks, gelfMaxChunks)
+	}
+	message, _ := fields["full_message"].(string)
+	if len(message) == 0 || len(message) >= len(full) || message != full[:len(message)] {
+		t.Errorf("the full message should be truncated, got %d bytes", len(message))
+	}
+	if fields["short_message"] != "Exception in thread \"main\"" || fields["_truncated"] != true || fields["_RawLogLine"] != nil {
+		t.Errorf("unexpected fields %v", fields["short_message"])
+	}
+
+	// The payloads that cannot be truncated are dropped, the next ones being
+	// sent as usual.
+	payload, _ = json.Marshal(gelf.GELFLogEntry{
+		Version:          gelf.Version,
+		Host:             "node-1",
+		ShortMessage:     "hello",
+		AdditionalFields: map[string]interface{}{"stack": full},
+	})
+	if err := o.Send(&Message{Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Send(&Message{Payload: []byte(`{"short_message":"next"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := receiveGELFUDP(t, conn); fields["short_message"] != "next" {
+		t.Errorf("unexpected message %v", fields)
+	}
+}
@@ -0,0 +1,175 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdemers/journald2graylog/blacklist"
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+// member is an output of a group, along with its filter and health.
+type member struct {
+	Output
+	include  *blacklist.Blacklist
+	exclude  blacklist.Blacklist
+	failedAt time.Time
+}
+
+// accepts returns true if the message passes the filter of the member.
+func (m *member) accepts(msg *Message) bool {
+	if m.include != nil && !m.include.IsBlacklisted(msg.Line) {
+		return false
+	}
+	return !m.exclude.IsBlacklisted(msg.Line)
+}
+
+// healthy returns true if the member did not fail during the last interval.
+func (m *member) healthy(now time.Time, interval time.Duration) bool {
+	return m.failedAt.IsZero() || now.Sub(m.failedAt) >= interval
+}
+
+// Group dispatches the messages between several outputs, according to the
+// output mode.
+type Group struct {
+	mode          string
+	retryInterval time.Duration
+	members       []*member
+	next          int
+
+	// mutex protects the health of the members, which is also read by
+	// Healthy.
+	mutex sync.Mutex
+}
+
+// NewGroup builds the outputs of the destinations and groups them, it closes
+// the outputs already built if one of them cannot be.
+func NewGroup(mode string, retryInterval time.Duration, destinations []config.Destination) (*Group, error) {
+	g := &Group{
+		mode:          mode,
+		retryInterval: retryInterval,
+	}
+	for _, d := range destinations {
+		o, err := New(d)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		m := &member{
+			Output:  o,
+			exclude: blacklist.FromList(d.Filter.Exclude),
+		}
+		if len(d.Filter.Include) > 0 {
+			include := blacklist.FromList(d.Filter.Include)
+			m.include = &include
+		}
+		g.members = append(g.members, m)
+	}
+	return g, nil
+}
+
+// Send forwards the message to the outputs whose filter accepts it. In fanout
+// mode it is sent to all of them, otherwise it is sent to the first one that
+// succeeds, trying the healthy outputs before those that recently failed.
+func (g *Group) Send(msg *Message) error {
+	var candidates []*member
+	for _, m := range g.members {
+		if m.accepts(msg) {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch g.mode {
+	case config.ModeFanout:
+		var failures []string
+		for _, m := range candidates {
+			err := g.send(m, msg)
+			if err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("%s", strings.Join(failures, "; "))
+		}
+		return nil
+	case config.ModeLoadBalance:
+		start := g.next % len(candidates)
+		g.next++
+		candidates = append(candidates[start:], candidates[:start]...)
+	}
+
+	// Try the healthy outputs first, and then the ones that failed recently
+	// as a last resort.
+	now := time.Now()
+	var healthy, unhealthy []*member
+	g.mutex.Lock()
+	for _, m := range candidates {
+		if m.healthy(now, g.retryInterval) {
+			healthy = append(healthy, m)
+		} else {
+			unhealthy = append(unhealthy, m)
+		}
+	}
+	g.mutex.Unlock()
+
+	var failures []string
+	for _, m := range append(healthy, unhealthy...) {
+		err := g.send(m, msg)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, err.Error())
+	}
+	return fmt.Errorf("all outputs failed: %s", strings.Join(failures, "; "))
+}
+
+// send forwards the message to a single member and records its health and
+// metrics.
+func (g *Group) send(m *member, msg *Message) error {
+	start := time.Now()
+	err := m.Send(msg)
+	metrics.SendLatency.Observe(time.Since(start).Seconds(), m.Name())
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if err != nil {
+		metrics.SendErrors.Inc(m.Name())
+		m.failedAt = time.Now()
+		return fmt.Errorf("output %s: %s", m.Name(), err)
+	}
+	metrics.MessagesSent.Inc(m.Name())
+	metrics.BytesSent.Add(float64(len(msg.Payload)), m.Name())
+	m.failedAt = time.Time{}
+	return nil
+}
+
+// Healthy returns true if at least one output of the group did not fail
+// during the last retry interval.
+func (g *Group) Healthy() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := time.Now()
+	for _, m := range g.members {
+		if m.healthy(now, g.retryInterval) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes all the outputs of the group.
+func (g *Group) Close() error {
+	var err error
+	for _, m := range g.members {
+		if cerr := m.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package output
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/blacklist"
+	"github.com/cdemers/journald2graylog/config"
+)
+
+type fakeOutput struct {
+	name string
+	fail bool
+	sent int
+}
+
+func (o *fakeOutput) Name() string { return o.name }
+
+func (o *fakeOutput) Send(m *Message) error {
+	if o.fail {
+		return errors.New("unreachable")
+	}
+	o.sent++
+	return nil
+}
+
+func (o *fakeOutput) Close() error { return nil }
+
+func newTestGroup(mode string, outputs ...*fakeOutput) *Group {
+	g := &Group{mode: mode, retryInterval: time.Minute}
+	for _, o := range outputs {
+		g.members = append(g.members, &member{Output: o})
+	}
+	return g
+}
+
+func TestFanout(t *testing.T) {
+	a, b := &fakeOutput{name: "a"}, &fakeOutput{name: "b"}
+	g := newTestGroup(config.ModeFanout, a, b)
+
+	if err := g.Send(&Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if a.sent != 1 || b.sent != 1 {
+		t.Errorf("every output should have received the message, got a:%d b:%d", a.sent, b.sent)
+	}
+
+	b.fail = true
+	if err := g.Send(&Message{}); err == nil {
+		t.Error("a failing output should be reported in fanout mode")
+	}
+	if a.sent != 2 {
+		t.Error("a failing output should not prevent the others from receiving the message")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	primary, dr := &fakeOutput{name: "primary", fail: true}, &fakeOutput{name: "dr"}
+	g := newTestGroup(config.ModeFailover, primary, dr)
+
+	if err := g.Send(&Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if dr.sent != 1 {
+		t.Error("the message should have been sent to the DR output")
+	}
+
+	// The primary output is now unhealthy, it is tried last even once it is
+	// back.
+	primary.fail = false
+	if err := g.Send(&Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if primary.sent != 0 || dr.sent != 2 {
+		t.Errorf("unexpected dispatch primary:%d dr:%d", primary.sent, dr.sent)
+	}
+
+	dr.fail = true
+	if err := g.Send(&Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if primary.sent != 1 {
+		t.Error("an unhealthy output should still be tried as a last resort")
+	}
+
+	primary.fail = true
+	if err := g.Send(&Message{}); err == nil {
+		t.Error("an error should be reported when all outputs fail")
+	}
+}
+
+func TestLoadBalance(t *testing.T) {
+	a, b, c := &fakeOutput{name: "a"}, &fakeOutput{name: "b"}, &fakeOutput{name: "c"}
+	g := newTestGroup(config.ModeLoadBalance, a, b, c)
+
+	for i := 0; i < 6; i++ {
+		if err := g.Send(&Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.sent != 2 || b.sent != 2 || c.sent != 2 {
+		t.Errorf("unbalanced dispatch a:%d b:%d c:%d", a.sent, b.sent, c.sent)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	all, kernel := &fakeOutput{name: "all"}, &fakeOutput{name: "kernel"}
+	g := newTestGroup(config.ModeFanout, all, kernel)
+	include := blacklist.FromList([]string{`"_TRANSPORT":"kernel"`})
+	g.members[1].include = &include
+	g.members[0].exclude = blacklist.FromList([]string{"noisy"})
+
+	g.Send(&Message{Line: []byte(`{"_TRANSPORT":"kernel"}`)})
+	g.Send(&Message{Line: []byte(`{"_TRANSPORT":"journal","MESSAGE":"noisy"}`)})
+
+	if all.sent != 1 || kernel.sent != 1 {
+		t.Errorf("unexpected dispatch all:%d kernel:%d", all.sent, kernel.sent)
+	}
+}
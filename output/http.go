@@ -0,0 +1,78 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+)
+
+const httpTimeout = 10 * time.Second
+
+// maxErrorBody is the number of bytes of the body of an error response kept
+// in the error.
+const maxErrorBody = 512
+
+// httpSender posts the batches of the destinations reached over HTTP.
+type httpSender struct {
+	client   *http.Client
+	username string
+	password string
+}
+
+func newHTTPSender(d config.Destination) (*httpSender, error) {
+	t, err := newTLSConfig(d.TLS, "")
+	if err != nil {
+		return nil, err
+	}
+	return &httpSender{
+		client: &http.Client{
+			Timeout:   httpTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: t},
+		},
+		username: d.Username,
+		password: d.Password,
+	}, nil
+}
+
+// post sends a body to an URL and returns the body of the response. The
+// network errors, and the responses asking to try again later, are
+// retryable errors.
+func (s *httpSender) post(url string, header http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	defer resp.Body.Close()
+	response, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	if resp.StatusCode/100 == 2 {
+		return response, nil
+	}
+
+	if len(response) > maxErrorBody {
+		response = response[:maxErrorBody]
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(response)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		return nil, retryableError{err}
+	}
+	return nil, err
+}
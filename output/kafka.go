@@ -0,0 +1,172 @@
+package output
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+var kafkaAcks = map[string]sarama.RequiredAcks{
+	config.KafkaAcksNone:   sarama.NoResponse,
+	config.KafkaAcksLeader: sarama.WaitForLocal,
+	config.KafkaAcksAll:    sarama.WaitForAll,
+}
+
+var kafkaCompressions = map[string]sarama.CompressionCodec{
+	config.KafkaCompressionNone:   sarama.CompressionNone,
+	config.KafkaCompressionGzip:   sarama.CompressionGZIP,
+	config.KafkaCompressionSnappy: sarama.CompressionSnappy,
+	config.KafkaCompressionLZ4:    sarama.CompressionLZ4,
+}
+
+// kafkaProducer is the part of the sarama asynchronous producer the Kafka
+// output relies on.
+type kafkaProducer interface {
+	Input() chan<- *sarama.ProducerMessage
+	Errors() <-chan *sarama.ProducerError
+	AsyncClose()
+}
+
+// newKafkaProducer is replaced by a fake producer in the tests.
+var newKafkaProducer = func(brokers []string, c *sarama.Config) (kafkaProducer, error) {
+	return sarama.NewAsyncProducer(brokers, c)
+}
+
+// kafka produces the GELF payloads to a Kafka topic, as read by the GELF
+// Kafka input of Graylog. The messages are batched and delivered in the
+// background by the producer.
+type kafka struct {
+	name     string
+	topic    string
+	key      string
+	producer kafkaProducer
+	// done is closed once the producer reported all its errors.
+	done chan struct{}
+
+	// mutex protects the delivery failures, which are logged by the next
+	// message.
+	mutex  sync.Mutex
+	failed int
+	err    error
+}
+
+func newKafka(d config.Destination) (*kafka, error) {
+	c, err := newKafkaConfig(d)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := newKafkaProducer(d.Kafka.Brokers, c)
+	if err != nil {
+		return nil, err
+	}
+	o := &kafka{
+		name:     d.Name,
+		topic:    d.Kafka.Topic,
+		key:      d.Kafka.Key,
+		producer: producer,
+		done:     make(chan struct{}),
+	}
+	go o.collectErrors()
+	return o, nil
+}
+
+// newKafkaConfig returns the configuration of the producer of a Kafka
+// destination, the batch parameters driving its flushes and retries.
+func newKafkaConfig(d config.Destination) (*sarama.Config, error) {
+	version, err := sarama.ParseKafkaVersion(d.Kafka.Version)
+	if err != nil {
+		return nil, err
+	}
+	c := sarama.NewConfig()
+	c.ClientID = "journald2graylog"
+	c.Version = version
+	c.Producer.RequiredAcks = kafkaAcks[d.Kafka.Acks]
+	c.Producer.Compression = kafkaCompressions[d.Kafka.Compression]
+	c.Producer.Return.Errors = true
+	c.Producer.Flush.Messages = d.Batch.Size
+	c.Producer.Flush.Bytes = d.Batch.Bytes
+	c.Producer.Flush.Frequency = d.Batch.Wait
+	c.Producer.Retry.Max = d.Batch.MaxRetries
+	if c.Producer.Retry.Max < 0 {
+		c.Producer.Retry.Max = 0
+	}
+	c.Producer.Retry.Backoff = d.Batch.RetryBackoff
+
+	switch d.Kafka.SecurityProtocol {
+	case config.KafkaSSL, config.KafkaSASLSSL:
+		t, err := newTLSConfig(d.TLS, "")
+		if err != nil {
+			return nil, err
+		}
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = t
+	}
+	switch d.Kafka.SecurityProtocol {
+	case config.KafkaSASLPlaintext, config.KafkaSASLSSL:
+		c.Net.SASL.Enable = true
+		c.Net.SASL.User = d.Username
+		c.Net.SASL.Password = d.Password
+	}
+	return c, c.Validate()
+}
+
+func (o *kafka) Name() string {
+	return o.name
+}
+
+// Send hands the message over to the producer. The previous messages that
+// could not be delivered are logged, they are not the failure of this one.
+func (o *kafka) Send(m *Message) error {
+	if err := o.takeError(); err != nil {
+		logging.WithFields(logging.Fields{"output": o.name, "error": err}).Warnf("Messages could not be delivered to Kafka.")
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:     o.topic,
+		Value:     sarama.ByteEncoder(m.Payload),
+		Timestamp: m.Time,
+	}
+	if o.key != config.KafkaKeyNone && m.Record != nil {
+		if key := labelValue(m, o.key); key != "" {
+			msg.Key = sarama.StringEncoder(key)
+		}
+	}
+	o.producer.Input() <- msg
+	return nil
+}
+
+// Close delivers the messages waiting in the producer.
+func (o *kafka) Close() error {
+	o.producer.AsyncClose()
+	<-o.done
+	return o.takeError()
+}
+
+// collectErrors records the delivery failures reported by the producer, and
+// counts them as send errors.
+func (o *kafka) collectErrors() {
+	defer close(o.done)
+	for e := range o.producer.Errors() {
+		metrics.SendErrors.Inc(o.name)
+		o.mutex.Lock()
+		o.failed++
+		o.err = e.Err
+		o.mutex.Unlock()
+	}
+}
+
+// takeError returns the delivery failures since the previous call, if any.
+func (o *kafka) takeError() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.err == nil {
+		return nil
+	}
+	err := fmt.Errorf("%d messages could not be delivered: %s", o.failed, o.err)
+	o.failed, o.err = 0, nil
+	return err
+}
@@ -0,0 +1,157 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/logging"
+)
+
+// fakeKafkaProducer stands in for the sarama producer, it keeps the messages
+// it is given and reports the errors pushed by the tests.
+type fakeKafkaProducer struct {
+	brokers []string
+	config  *sarama.Config
+	input   chan *sarama.ProducerMessage
+	errors  chan *sarama.ProducerError
+}
+
+func (p *fakeKafkaProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *fakeKafkaProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *fakeKafkaProducer) AsyncClose() {
+	close(p.errors)
+}
+
+// useFakeKafkaProducer makes the Kafka outputs use a fake producer, it
+// returns the function restoring the sarama producer.
+func useFakeKafkaProducer(fake *fakeKafkaProducer) func() {
+	previous := newKafkaProducer
+	newKafkaProducer = func(brokers []string, c *sarama.Config) (kafkaProducer, error) {
+		fake.brokers, fake.config = brokers, c
+		return fake, nil
+	}
+	return func() { newKafkaProducer = previous }
+}
+
+func testKafkaDestination() config.Destination {
+	return config.Destination{
+		Name:     "kafka",
+		Type:     config.TypeKafka,
+		Username: "journald",
+		Password: "secret",
+		Kafka: config.Kafka{
+			Brokers:          []string{"kafka-1:9093", "kafka-2:9093"},
+			Topic:            "gelf",
+			Key:              "unit",
+			Acks:             config.KafkaAcksAll,
+			Compression:      config.KafkaCompressionLZ4,
+			Version:          "1.0.0",
+			SecurityProtocol: config.KafkaSASLSSL,
+		},
+		Batch: config.Batch{Size: 500, Bytes: 1 << 20, Wait: time.Second, MaxRetries: -1, RetryBackoff: time.Second},
+	}
+}
+
+func TestKafkaConfig(t *testing.T) {
+	c, err := newKafkaConfig(testKafkaDestination())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Producer.RequiredAcks != sarama.WaitForAll || c.Producer.Compression != sarama.CompressionLZ4 || c.Version != sarama.V1_0_0_0 {
+		t.Errorf("unexpected producer configuration %+v", c.Producer)
+	}
+	if c.Producer.Flush.Messages != 500 || c.Producer.Flush.Frequency != time.Second || c.Producer.Retry.Max != 0 {
+		t.Errorf("the batch parameters should drive the flushes and the retries, got %+v", c.Producer)
+	}
+	if !c.Net.TLS.Enable || !c.Net.SASL.Enable || c.Net.SASL.User != "journald" || c.Net.SASL.Password != "secret" {
+		t.Errorf("unexpected network configuration %+v", c.Net)
+	}
+
+	d := testKafkaDestination()
+	d.Kafka.SecurityProtocol = config.KafkaPlaintext
+	d.Kafka.Version = "1.0"
+	if _, err := newKafkaConfig(d); err == nil {
+		t.Error("an invalid version should be rejected")
+	}
+	d.Kafka.Version = "0.10.2.0"
+	c, err = newKafkaConfig(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Net.TLS.Enable || c.Net.SASL.Enable {
+		t.Errorf("the plaintext protocol should use neither TLS nor SASL, got %+v", c.Net)
+	}
+}
+
+func TestKafkaSend(t *testing.T) {
+	fake := &fakeKafkaProducer{
+		input:  make(chan *sarama.ProducerMessage, 10),
+		errors: make(chan *sarama.ProducerError, 10),
+	}
+	defer useFakeKafkaProducer(fake)()
+	o, err := newKafka(testKafkaDestination())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.brokers[1] != "kafka-2:9093" {
+		t.Errorf("unexpected brokers %v", fake.brokers)
+	}
+
+	m := testLokiMessage("ssh.service", "6", "hello", 1)
+	if err := o.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-fake.input
+	key, _ := msg.Key.Encode()
+	value, _ := msg.Value.Encode()
+	if msg.Topic != "gelf" || string(key) != "ssh.service" || string(value) != string(m.Payload) || !msg.Timestamp.Equal(m.Time) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// The messages without a value for the key are sent without one.
+	if err := o.Send(testLokiMessage("", "6", "hello", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-fake.input; msg.Key != nil {
+		t.Errorf("unexpected key %v", msg.Key)
+	}
+
+	// The delivery failures are logged by the next message, which is sent
+	// nonetheless.
+	var warnings []logging.Record
+	logging.SetHook(func(r logging.Record) { warnings = append(warnings, r) })
+	defer logging.SetHook(nil)
+	fake.errors <- &sarama.ProducerError{Err: errors.New("kafka: broker not available")}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(warnings) == 0 {
+		if err := o.Send(m); err != nil {
+			t.Fatal(err)
+		}
+		<-fake.input
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery failure was not logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(fmt.Sprint(warnings[0].Fields["error"]), "1 messages could not be delivered: kafka: broker not available") {
+		t.Errorf("unexpected warning %v", warnings[0].Fields)
+	}
+
+	// And reported by the closing of the output.
+	fake.errors <- &sarama.ProducerError{Err: errors.New("kafka: message too large")}
+	if err := o.Close(); err == nil {
+		t.Error("the delivery failure should be reported when closing")
+	}
+}
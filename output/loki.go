@@ -0,0 +1,288 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Label sources, the properties of the entries the Loki labels can be taken
+// from besides their fields.
+const (
+	labelHost       = "host"
+	labelUnit       = "unit"
+	labelPriority   = "priority"
+	labelIdentifier = "identifier"
+	labelFacility   = "facility"
+	labelTransport  = "transport"
+)
+
+// lokiLabelEscaper escapes the label values as in the LogQL stream selectors.
+var lokiLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// loki pushes the entries to Grafana Loki, in batches, grouped in streams by
+// their labels.
+type loki struct {
+	name         string
+	url          string
+	sender       *httpSender
+	header       http.Header
+	format       string
+	line         string
+	labels       map[string]string
+	staticLabels map[string]string
+	maxRetries   int
+	retryBackoff time.Duration
+	batcher      *batcher
+}
+
+func newLoki(d config.Destination) (*loki, error) {
+	sender, err := newHTTPSender(d)
+	if err != nil {
+		return nil, err
+	}
+	o := &loki{
+		name:         d.Name,
+		url:          d.URL,
+		sender:       sender,
+		header:       http.Header{},
+		format:       d.Loki.Format,
+		line:         d.Loki.Line,
+		labels:       d.Loki.Labels,
+		staticLabels: d.Loki.StaticLabels,
+		maxRetries:   d.Batch.MaxRetries,
+		retryBackoff: d.Batch.RetryBackoff,
+	}
+	if d.Loki.Format == config.LokiJSON {
+		o.header.Set("Content-Type", "application/json")
+	} else {
+		o.header.Set("Content-Type", "application/x-protobuf")
+	}
+	if d.Loki.TenantID != "" {
+		o.header.Set("X-Scope-OrgID", d.Loki.TenantID)
+	}
+	o.batcher = newBatcher(d.Batch, o.push)
+	return o, nil
+}
+
+func (o *loki) Name() string {
+	return o.name
+}
+
+// Send adds the message to the batch, which is pushed once full or after
+// the batch wait.
+func (o *loki) Send(m *Message) error {
+	if m.Record == nil {
+		return fmt.Errorf("the message has no record to send")
+	}
+	return o.batcher.add(m)
+}
+
+// Close pushes the messages waiting in the batch.
+func (o *loki) Close() error {
+	return o.batcher.close()
+}
+
+// lokiEntry is a line of a Loki stream.
+type lokiEntry struct {
+	time time.Time
+	line string
+}
+
+// lokiStream is a Loki stream, the entries sharing the same labels.
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+// push sends a batch of messages to Loki.
+func (o *loki) push(batch []*Message) error {
+	streams := o.streams(batch)
+	var body []byte
+	if o.format == config.LokiJSON {
+		var err error
+		body, err = encodeLokiJSON(streams)
+		if err != nil {
+			return err
+		}
+	} else {
+		body = snappy.Encode(nil, encodeLokiProtobuf(streams))
+	}
+	return withRetries(o.maxRetries, o.retryBackoff, func() error {
+		_, err := o.sender.post(o.url, o.header, body)
+		return err
+	})
+}
+
+// streams groups the messages of a batch in streams, in the order of their
+// first message, the entries of a stream being sorted by time as Loki
+// requires.
+func (o *loki) streams(batch []*Message) []*lokiStream {
+	var streams []*lokiStream
+	byLabels := map[string]*lokiStream{}
+	for _, m := range batch {
+		labels := o.extractLabels(m)
+		key := formatLokiLabels(labels)
+		s, ok := byLabels[key]
+		if !ok {
+			s = &lokiStream{labels: labels}
+			byLabels[key] = s
+			streams = append(streams, s)
+		}
+		t := m.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		line := string(m.Payload)
+		if o.line == config.LokiLineMessage {
+			line = messageText(m.Record)
+		}
+		s.entries = append(s.entries, lokiEntry{time: t, line: line})
+	}
+	for _, s := range streams {
+		entries := s.entries
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+	}
+	return streams
+}
+
+// extractLabels returns the labels of a message, those whose value is empty
+// are left out.
+func (o *loki) extractLabels(m *Message) map[string]string {
+	labels := map[string]string{}
+	for name, value := range o.staticLabels {
+		labels[name] = value
+	}
+	for name, source := range o.labels {
+		if value := labelValue(m, source); value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// labelValue returns the value of a label source for a message.
+func labelValue(m *Message, source string) string {
+	e := &m.Record.Entry
+	switch source {
+	case labelHost:
+		return m.Host
+	case labelUnit:
+		if e.SystemdUnit != "" {
+			return e.SystemdUnit
+		}
+		return e.SystemdUserUnit
+	case labelPriority:
+		priority, err := strconv.Atoi(e.Priority)
+		if err != nil || journald.PriorityName(priority) == "" {
+			priority = journald.PriorityInfo
+		}
+		return journald.PriorityName(priority)
+	case labelIdentifier:
+		return e.Identifier()
+	case labelFacility:
+		return journald.FacilityName(e.SyslogFacility)
+	case labelTransport:
+		return e.Transport
+	}
+	if value, ok := e.Field(source); ok {
+		return value
+	}
+	if value, ok := m.Record.Fields[source]; ok {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// formatLokiLabels returns the labels of a stream in the LogQL syntax, such
+// as {host="node-1", unit="ssh.service"}.
+func formatLokiLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + lokiLabelEscaper.Replace(labels[name]) + `"`
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// encodeLokiJSON encodes the streams in the JSON format of the push API.
+func encodeLokiJSON(streams []*lokiStream) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	push := struct {
+		Streams []stream `json:"streams"`
+	}{}
+	for _, s := range streams {
+		values := make([][2]string, len(s.entries))
+		for i, e := range s.entries {
+			values[i] = [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line}
+		}
+		push.Streams = append(push.Streams, stream{Stream: s.labels, Values: values})
+	}
+	return json.Marshal(push)
+}
+
+// encodeLokiProtobuf encodes the streams in the protobuf format of the push
+// API, before its snappy compression:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func encodeLokiProtobuf(streams []*lokiStream) []byte {
+	var push []byte
+	for _, s := range streams {
+		stream := appendProtoBytes(nil, 1, []byte(formatLokiLabels(s.labels)))
+		for _, e := range s.entries {
+			var timestamp []byte
+			timestamp = appendProtoVarint(timestamp, 1, uint64(e.time.Unix()))
+			timestamp = appendProtoVarint(timestamp, 2, uint64(e.time.Nanosecond()))
+			entry := appendProtoBytes(nil, 1, timestamp)
+			entry = appendProtoBytes(entry, 2, []byte(e.line))
+			stream = appendProtoBytes(stream, 2, entry)
+		}
+		push = appendProtoBytes(push, 1, stream)
+	}
+	return push
+}
+
+// appendProtoVarint appends a varint field to a protobuf message, the zero
+// values are left out.
+func appendProtoVarint(b []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3)
+	return appendVarint(b, value)
+}
+
+// appendProtoBytes appends a length-delimited field, a string or an embedded
+// message, to a protobuf message.
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|2)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendVarint(b []byte, value uint64) []byte {
+	for value >= 0x80 {
+		b = append(b, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(b, byte(value))
+}
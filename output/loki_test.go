@@ -0,0 +1,277 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// lokiStandIn is a local stand-in for the Loki push API, it records the
+// requests it receives and answers with the given statuses, then with 204.
+type lokiStandIn struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newLokiStandIn(statuses ...int) *lokiStandIn {
+	s := &lokiStandIn{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *lokiStandIn) received() ([]*http.Request, [][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.bodies
+}
+
+func testLokiDestination(url string, format string) config.Destination {
+	return config.Destination{
+		Name: "loki",
+		Type: config.TypeLoki,
+		URL:  url + "/loki/api/v1/push",
+		Loki: config.Loki{
+			Format:       format,
+			Labels:       map[string]string{"host": "host", "unit": "unit", "priority": "priority", "app": "app"},
+			StaticLabels: map[string]string{"job": "journald"},
+			Line:         config.LokiLineMessage,
+			TenantID:     "team-a",
+		},
+		Batch: config.Batch{Size: 3, Bytes: 1 << 20, Wait: time.Hour, MaxRetries: 2, RetryBackoff: time.Millisecond},
+	}
+}
+
+func testLokiMessage(unit string, priority string, message string, second int) *Message {
+	return &Message{
+		Payload: []byte(`{"short_message":"` + message + `"}`),
+		Record: &journald.Record{
+			Entry: journald.JournaldJSONLogEntry{
+				Message:     message,
+				Priority:    priority,
+				SystemdUnit: unit,
+			},
+			Fields: map[string]interface{}{"app": "shop"},
+		},
+		Host: "node-1",
+		Time: time.Date(2018, 3, 14, 15, 9, second, 0, time.UTC),
+	}
+}
+
+func TestLokiJSON(t *testing.T) {
+	server := newLokiStandIn()
+	defer server.Close()
+	o, err := newLoki(testLokiDestination(server.URL, config.LokiJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch is pushed once it holds 3 messages.
+	for _, m := range []*Message{
+		testLokiMessage("ssh.service", "6", "second", 2),
+		testLokiMessage("cron.service", "3", "other", 1),
+		testLokiMessage("ssh.service", "6", "first", 1),
+	} {
+		if err := o.Send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests, bodies := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected a single push, got %d", len(requests))
+	}
+	if r := requests[0]; r.URL.Path != "/loki/api/v1/push" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Scope-OrgID") != "team-a" {
+		t.Errorf("unexpected request %s %s", r.URL.Path, r.Header)
+	}
+
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(bodies[0], &push); err != nil {
+		t.Fatal(err)
+	}
+	if len(push.Streams) != 2 {
+		t.Fatalf("expected 2 streams, got %s", bodies[0])
+	}
+	expectedLabels := map[string]string{"job": "journald", "host": "node-1", "unit": "ssh.service", "priority": "info", "app": "shop"}
+	if !reflect.DeepEqual(push.Streams[0].Stream, expectedLabels) {
+		t.Errorf("got labels %v, expected %v", push.Streams[0].Stream, expectedLabels)
+	}
+	expectedValues := [][2]string{{"1521040141000000000", "first"}, {"1521040142000000000", "second"}}
+	if !reflect.DeepEqual(push.Streams[0].Values, expectedValues) {
+		t.Errorf("got values %v, expected them sorted by time: %v", push.Streams[0].Values, expectedValues)
+	}
+	if push.Streams[1].Stream["priority"] != "err" {
+		t.Errorf("unexpected labels %v", push.Streams[1].Stream)
+	}
+}
+
+func TestLokiProtobuf(t *testing.T) {
+	server := newLokiStandIn()
+	defer server.Close()
+	o, err := newLoki(testLokiDestination(server.URL, config.LokiProtobuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch is pushed when the output is closed.
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if requests, _ := server.received(); len(requests) != 0 {
+		t.Fatal("the batch should wait to be full")
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	requests, bodies := server.received()
+	if len(requests) != 1 || requests[0].Header.Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("expected a single protobuf push, got %d", len(requests))
+	}
+
+	decoded, err := snappy.Decode(nil, bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := decodeProto(t, decodeProto(t, decoded)[1][0])
+	labels := `{app="shop", host="node-1", job="journald", priority="info", unit="ssh.service"}`
+	if string(stream[1][0]) != labels {
+		t.Errorf("got labels %s, expected %s", stream[1][0], labels)
+	}
+	entry := decodeProto(t, stream[2][0])
+	if string(entry[2][0]) != "hello" {
+		t.Errorf("got line %q, expected hello", entry[2][0])
+	}
+	timestamp := decodeProto(t, entry[1][0])
+	if string(timestamp[1][0]) != "1521040141" {
+		t.Errorf("got seconds %s, expected 1521040141", timestamp[1][0])
+	}
+}
+
+func TestLokiRetries(t *testing.T) {
+	server := newLokiStandIn(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer server.Close()
+	d := testLokiDestination(server.URL, config.LokiJSON)
+	d.Batch.Size = 1
+	o, err := newLoki(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 1)); err != nil {
+		t.Fatalf("the push should have succeeded on the third attempt: %s", err)
+	}
+	if requests, _ := server.received(); len(requests) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(requests))
+	}
+
+	// The client errors are not retried.
+	server.mutex.Lock()
+	server.statuses = []int{http.StatusBadRequest}
+	server.mutex.Unlock()
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 1)); err == nil {
+		t.Error("a rejected push should fail")
+	}
+	if requests, _ := server.received(); len(requests) != 4 {
+		t.Errorf("expected a single attempt of the rejected push, got %d", len(requests)-3)
+	}
+}
+
+func TestLokiBatchWait(t *testing.T) {
+	server := newLokiStandIn(http.StatusBadRequest)
+	defer server.Close()
+	d := testLokiDestination(server.URL, config.LokiJSON)
+	d.Batch.Wait = 10 * time.Millisecond
+	o, err := newLoki(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 1)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		o.batcher.mutex.Lock()
+		failed := o.batcher.err != nil
+		o.batcher.mutex.Unlock()
+		if failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the batch was not pushed after the batch wait")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if requests, _ := server.received(); len(requests) != 1 {
+		t.Fatalf("expected a single push, got %d", len(requests))
+	}
+	// The failure of the push triggered by the timer is reported by the
+	// next message.
+	if err := o.Send(testLokiMessage("ssh.service", "6", "hello", 2)); err == nil {
+		t.Error("the failure of the previous push should be reported")
+	}
+}
+
+// decodeProto decodes the fields of a protobuf message, the varints being
+// returned as decimal text.
+func decodeProto(t *testing.T, b []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+	readVarint := func() uint64 {
+		var value uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(b) == 0 {
+				t.Fatal("truncated varint")
+			}
+			c := b[0]
+			b = b[1:]
+			value |= uint64(c&0x7f) << shift
+			if c < 0x80 {
+				return value
+			}
+		}
+	}
+	for len(b) > 0 {
+		key := readVarint()
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			fields[field] = append(fields[field], []byte(fmt.Sprint(readVarint())))
+		case 2:
+			n := int(readVarint())
+			if n > len(b) {
+				t.Fatal("truncated field")
+			}
+			fields[field] = append(fields[field], b[:n])
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
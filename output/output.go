@@ -0,0 +1,62 @@
+package output
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Message is a log entry ready to be forwarded, in both its original journald
+// JSON form and its GELF JSON encoded form, along with the processed record
+// for the outputs using other formats.
+type Message struct {
+	// Line is the raw journald JSON log line.
+	Line []byte
+	// Payload is the GELF JSON payload built from the line.
+	Payload []byte
+	// Record is the processed record the payload was built from.
+	Record *journald.Record
+	// Host and Time are the host and the timestamp the entry is sent with.
+	Host string
+	Time time.Time
+	// Diagnostic is set on the messages shipping the diagnostics of
+	// journald2graylog itself.
+	Diagnostic bool
+}
+
+// Output is implemented by every destination journald2graylog can forward
+// log entries to.
+type Output interface {
+	// Name returns the name of the destination, as given in the
+	// configuration.
+	Name() string
+	// Send forwards a single message to the destination.
+	Send(m *Message) error
+	// Close releases the resources held by the output.
+	Close() error
+}
+
+// New builds the output matching the type of the destination.
+func New(d config.Destination) (Output, error) {
+	switch d.Type {
+	case config.TypeGELFUDP:
+		return newGELFUDP(d), nil
+	case config.TypeGELFTCP:
+		return newGELFTCP(d), nil
+	case config.TypeGELFAMQP:
+		return newGELFAMQP(d)
+	case config.TypeSyslogUDP, config.TypeSyslogTCP, config.TypeSyslogTLS:
+		return newSyslog(d)
+	case config.TypeLoki:
+		return newLoki(d)
+	case config.TypeElasticsearch:
+		return newElasticsearch(d)
+	case config.TypeKafka:
+		return newKafka(d)
+	case config.TypeFile:
+		return newFile(d)
+	}
+	return nil, fmt.Errorf("unknown output type %q", d.Type)
+}
@@ -0,0 +1,279 @@
+package output
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+const syslogTimeout = 10 * time.Second
+
+// syslogUser is the facility of the entries without a valid one, user-level
+// messages.
+const syslogUser = 1
+
+// syslogHeaderFields are the journal fields sent in the header of the syslog
+// messages rather than in their structured data.
+var syslogHeaderFields = map[string]bool{
+	"MESSAGE":                   true,
+	"PRIORITY":                  true,
+	"SYSLOG_FACILITY":           true,
+	"SYSLOG_IDENTIFIER":         true,
+	"SYSLOG_PID":                true,
+	"MESSAGE_ID":                true,
+	"_HOSTNAME":                 true,
+	"CONTAINER_PARTIAL_MESSAGE": true,
+}
+
+// invalidSyslogCharacters matches the characters that are not allowed in the
+// header fields and the structured data names, printable US-ASCII only.
+var invalidSyslogCharacters = regexp.MustCompile(`[^!-~]`)
+
+// invalidSDNameCharacters matches the printable characters that are not
+// allowed in the structured data names either.
+var invalidSDNameCharacters = regexp.MustCompile(`[= \]"]`)
+
+// sdValueEscaper escapes the characters RFC 5424 requires to be escaped in
+// the structured data values.
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslog sends the entries to a syslog server, over UDP, or over TCP or TLS
+// with the octet counting framing of RFC 6587 and RFC 5425.
+type syslog struct {
+	name    string
+	network string
+	address string
+	tls     *tls.Config
+	format  string
+	sdID    string
+	// maxSize is the size above which the messages are truncated, over UDP
+	// only.
+	maxSize int
+	conn    net.Conn
+}
+
+func newSyslog(d config.Destination) (*syslog, error) {
+	o := &syslog{
+		name:    d.Name,
+		network: "tcp",
+		address: net.JoinHostPort(d.Hostname, fmt.Sprint(d.Port)),
+		format:  d.Syslog.Format,
+		sdID:    d.Syslog.SDID,
+		maxSize: d.Syslog.MaxSize,
+	}
+	switch d.Type {
+	case config.TypeSyslogUDP:
+		o.network = "udp"
+	case config.TypeSyslogTLS:
+		t, err := newTLSConfig(d.TLS, d.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		o.tls = t
+	}
+	return o, nil
+}
+
+func (o *syslog) Name() string {
+	return o.name
+}
+
+func (o *syslog) Send(m *Message) error {
+	if m.Record == nil {
+		return fmt.Errorf("the message has no record to send")
+	}
+	var message []byte
+	if o.format == config.SyslogRFC3164 {
+		message = formatRFC3164(m)
+	} else {
+		message = formatRFC5424(m, o.sdID)
+	}
+
+	if o.conn == nil {
+		dialer := &net.Dialer{Timeout: syslogTimeout}
+		var conn net.Conn
+		var err error
+		if o.tls != nil {
+			conn, err = tls.DialWithDialer(dialer, o.network, o.address, o.tls)
+		} else {
+			conn, err = dialer.Dial(o.network, o.address)
+		}
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+
+	frame := message
+	if o.network != "udp" {
+		frame = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	} else if len(frame) > o.maxSize {
+		// The messages too big for a datagram are truncated, as RFC 5424
+		// expects the receivers to do.
+		n := o.maxSize
+		for n > 0 && !utf8.RuneStart(frame[n]) {
+			n--
+		}
+		frame = frame[:n]
+		metrics.TruncatedPayloads.Inc(o.name)
+	}
+	o.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := o.conn.Write(frame)
+	if err != nil {
+		// Drop the connection, it will be established again on the next
+		// message.
+		o.conn.Close()
+		o.conn = nil
+	}
+	return err
+}
+
+func (o *syslog) Close() error {
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+// syslogPriority returns the PRI of an entry, from its facility and its
+// severity.
+func syslogPriority(e *journald.JournaldJSONLogEntry) int {
+	facility, err := strconv.Atoi(e.SyslogFacility)
+	if err != nil || facility < 0 || facility > 23 {
+		facility = syslogUser
+	}
+	severity, err := strconv.Atoi(e.Priority)
+	if err != nil || journald.PriorityName(severity) == "" {
+		severity = journald.PriorityInfo
+	}
+	return facility*8 + severity
+}
+
+// messageText returns the text of a record, its full message if it has
+// one.
+func messageText(r *journald.Record) string {
+	if r.FullMessage != "" {
+		return r.FullMessage
+	}
+	return r.Entry.Message
+}
+
+// appName returns the application the entry was logged by.
+func appName(e *journald.JournaldJSONLogEntry) string {
+	if name := e.Identifier(); name != "" {
+		return name
+	}
+	return e.Command
+}
+
+// procID returns the process the entry was logged by.
+func procID(e *journald.JournaldJSONLogEntry) string {
+	if e.SyslogPID != "" {
+		return e.SyslogPID
+	}
+	return e.PID
+}
+
+// headerField returns a header field of a RFC 5424 message, printable
+// US-ASCII of at most max bytes, or the nil value if it is empty.
+func headerField(value string, max int) string {
+	value = invalidSyslogCharacters.ReplaceAllString(value, "_")
+	if len(value) > max {
+		value = value[:max]
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// formatRFC5424 renders a message in the RFC 5424 format, with the journal
+// fields and the additional fields in a structured data element.
+func formatRFC5424(m *Message, sdID string) []byte {
+	e := &m.Record.Entry
+	timestamp := "-"
+	if !m.Time.IsZero() {
+		timestamp = m.Time.Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ", syslogPriority(e), timestamp,
+		headerField(m.Host, 255), headerField(appName(e), 48),
+		headerField(procID(e), 128), headerField(e.MessageID, 32))
+	params := structuredData(m.Record)
+	if len(params) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + sdID)
+		for _, p := range params {
+			fmt.Fprintf(&b, ` %s="%s"`, p[0], sdValueEscaper.Replace(p[1]))
+		}
+		b.WriteString("]")
+	}
+	if message := messageText(m.Record); message != "" {
+		b.WriteString(" " + message)
+	}
+	return b.Bytes()
+}
+
+// formatRFC3164 renders a message in the legacy BSD syslog format of RFC
+// 3164, which has no structured data.
+func formatRFC3164(m *Message) []byte {
+	e := &m.Record.Entry
+	tag := headerField(appName(e), 32)
+	if pid := procID(e); pid != "" {
+		tag += "[" + headerField(pid, 10) + "]"
+	}
+	return []byte(fmt.Sprintf("<%d>%s %s %s: %s", syslogPriority(e), m.Time.Format(time.Stamp),
+		headerField(m.Host, 255), tag, messageText(m.Record)))
+}
+
+// structuredData returns the parameters of the structured data of a record:
+// its journal fields that are not in the header, then its additional fields
+// in the order of their names.
+func structuredData(r *journald.Record) [][2]string {
+	var params [][2]string
+	entry := reflect.ValueOf(r.Entry)
+	for i := 0; i < entry.NumField(); i++ {
+		name := strings.Split(entry.Type().Field(i).Tag.Get("json"), ",")[0]
+		value := entry.Field(i).String()
+		if value == "" || syslogHeaderFields[name] || strings.HasPrefix(name, "__") {
+			continue
+		}
+		params = append(params, [2]string{name, value})
+	}
+
+	names := make([]string, 0, len(r.Fields))
+	for name := range r.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, [2]string{sdName(name), fmt.Sprint(r.Fields[name])})
+	}
+	return params
+}
+
+// sdName returns a valid structured data parameter name built from name.
+func sdName(name string) string {
+	name = invalidSyslogCharacters.ReplaceAllString(name, "_")
+	name = invalidSDNameCharacters.ReplaceAllString(name, "_")
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
@@ -0,0 +1,213 @@
+package output
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+func testSyslogMessage() *Message {
+	return &Message{
+		Record: &journald.Record{
+			Entry: journald.JournaldJSONLogEntry{
+				Message:          "Accepted publickey for alice",
+				Priority:         "6",
+				SyslogFacility:   "10",
+				SyslogIdentifier: "sshd",
+				PID:              "1234",
+				UID:              "0",
+				SystemdUnit:      "ssh.service",
+				Hostname:         "node-1",
+				Cursor:           "s=123",
+			},
+			Fields: map[string]interface{}{
+				"user":     "alice",
+				"path]x=y": `C:\tmp "quoted"`,
+			},
+		},
+		Host: "node-1",
+		Time: time.Date(2018, 3, 14, 15, 9, 26, 535123000, time.UTC),
+	}
+}
+
+func TestFormatRFC5424(t *testing.T) {
+	actual := string(formatRFC5424(testSyslogMessage(), "journal@32473"))
+	expected := `<86>1 2018-03-14T15:09:26.535123Z node-1 sshd 1234 - ` +
+		`[journal@32473 _PID="1234" _UID="0" _SYSTEMD_UNIT="ssh.service" path_x_y="C:\\tmp \"quoted\"" user="alice"] ` +
+		`Accepted publickey for alice`
+	if actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+}
+
+func TestFormatRFC5424NilValues(t *testing.T) {
+	m := &Message{Record: &journald.Record{Entry: journald.JournaldJSONLogEntry{Priority: "high"}}}
+	if actual, expected := string(formatRFC5424(m, "journal@32473")), "<14>1 - - - - - -"; actual != expected {
+		t.Errorf("got %q, expected %q", actual, expected)
+	}
+}
+
+func TestFormatRFC3164(t *testing.T) {
+	actual := string(formatRFC3164(testSyslogMessage()))
+	expected := "<86>Mar 14 15:09:26 node-1 sshd[1234]: Accepted publickey for alice"
+	if actual != expected {
+		t.Errorf("got %q, expected %q", actual, expected)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	o, err := newSyslog(testSyslogDestination(config.TypeSyslogUDP, conn.LocalAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	if err := o.Send(testSyslogMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := string(formatRFC3164(testSyslogMessage())); string(buffer[:n]) != expected {
+		t.Errorf("got %q, expected %q", buffer[:n], expected)
+	}
+
+	// The messages bigger than the maximum size are truncated, on a
+	// character boundary.
+	m := testSyslogMessage()
+	m.Record.Entry.Message = strings.Repeat("é", 100000)
+	if err := o.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err = conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := string(formatRFC3164(m)); n != 1023 || string(buffer[:n]) != expected[:n] {
+		t.Errorf("got %d bytes, expected the first 1023 bytes of the message", n)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	testSyslogStream(t, listener, testSyslogDestination(config.TypeSyslogTCP, listener.Addr()))
+}
+
+func TestSyslogTLS(t *testing.T) {
+	// Borrow the certificate of the test HTTP server.
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: server.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	dir, err := ioutil.TempDir("", "journald2graylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, block, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := testSyslogDestination(config.TypeSyslogTLS, listener.Addr())
+	d.Syslog.Format = config.SyslogRFC5424
+	d.TLS.CAFile = ca
+	testSyslogStream(t, listener, d)
+}
+
+func testSyslogDestination(kind string, addr net.Addr) config.Destination {
+	host, port, _ := net.SplitHostPort(addr.String())
+	p, _ := strconv.Atoi(port)
+	return config.Destination{
+		Name:     "syslog",
+		Type:     kind,
+		Hostname: host,
+		Port:     p,
+		Syslog:   config.Syslog{Format: config.SyslogRFC3164, SDID: "journal@32473", MaxSize: 1024},
+	}
+}
+
+// testSyslogStream sends two messages to a stream listener, and checks that
+// they are received with the octet counting framing.
+func testSyslogStream(t *testing.T, listener net.Listener, d config.Destination) {
+	o, err := newSyslog(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+		var frames []string
+		for len(frames) < 2 {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				received <- err.Error()
+				return
+			}
+			frames = append(frames, string(frame))
+		}
+		received <- strings.Join(frames, "\n")
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := o.Send(testSyslogMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var message []byte
+	if d.Syslog.Format == config.SyslogRFC3164 {
+		message = formatRFC3164(testSyslogMessage())
+	} else {
+		message = formatRFC5424(testSyslogMessage(), d.Syslog.SDID)
+	}
+	expected := string(message) + "\n" + string(message)
+	if actual := <-received; actual != expected {
+		t.Errorf("got\n%s\nexpected\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/cdemers/journald2graylog/config"
+)
+
+// newTLSConfig returns the TLS configuration of the connections to a
+// destination, its server name defaults to the hostname of the destination.
+func newTLSConfig(c config.TLS, hostname string) (*tls.Config, error) {
+	t := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if t.ServerName == "" {
+		t.ServerName = hostname
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA file: %s", err)
+		}
+		t.RootCAs = x509.NewCertPool()
+		if !t.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the CA file %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %s", err)
+		}
+		t.Certificates = []tls.Certificate{certificate}
+	}
+	return t, nil
+}
@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// levels maps the level names commonly used by logging libraries to the
+// syslog severities used by journald and GELF.
+var levels = map[string]int{
+	"emerg":         0,
+	"emergency":     0,
+	"panic":         0,
+	"alert":         1,
+	"fatal":         2,
+	"crit":          2,
+	"critical":      2,
+	"err":           3,
+	"error":         3,
+	"warn":          4,
+	"warning":       4,
+	"notice":        5,
+	"info":          6,
+	"information":   6,
+	"informational": 6,
+	"debug":         7,
+	"trace":         7,
+}
+
+// ParseLevel returns the syslog severity of a level found in a message. The
+// level may be a name, a syslog severity, or one of the numeric levels of
+// the bunyan and pino libraries (10 for trace up to 60 for fatal).
+func ParseLevel(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		if level, ok := levels[strings.ToLower(strings.TrimSpace(v))]; ok {
+			return level, true
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			return numericLevel(n)
+		}
+	case json.Number:
+		if n, err := strconv.Atoi(string(v)); err == nil {
+			return numericLevel(n)
+		}
+	case int:
+		return numericLevel(v)
+	case int64:
+		return numericLevel(int(v))
+	}
+	return 0, false
+}
+
+func numericLevel(n int) (int, bool) {
+	switch {
+	case n >= 0 && n <= 7:
+		return n, true
+	case n == 10 || n == 20:
+		return 7, true
+	case n == 30:
+		return 6, true
+	case n == 40:
+		return 4, true
+	case n == 50:
+		return 3, true
+	case n == 60:
+		return 2, true
+	}
+	return 0, false
+}
+
+// timeLayouts are the layouts of the textual timestamps understood by
+// ParseTimestamp.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	"02/Jan/2006:15:04:05 -0700",
+}
+
+// ParseTimestamp returns the time of a timestamp found in a message. The
+// timestamp may be textual, most commonly RFC 3339, or a number of seconds,
+// milliseconds, microseconds or nanoseconds since the epoch, the unit being
+// guessed from its magnitude. The timestamps without a time zone are UTC.
+func ParseTimestamp(value interface{}) (time.Time, bool) {
+	var n float64
+	switch v := value.(type) {
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		n = f
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		n = f
+	case float64:
+		n = v
+	case int64:
+		n = float64(v)
+	default:
+		return time.Time{}, false
+	}
+
+	if n <= 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return time.Time{}, false
+	}
+	switch {
+	case n >= 1e17:
+		n /= 1e9
+	case n >= 1e14:
+		n /= 1e6
+	case n >= 1e11:
+		n /= 1e3
+	}
+	seconds, fraction := math.Modf(n)
+	return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), true
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		level int
+		ok    bool
+	}{
+		{"error", 3, true},
+		{"WARN", 4, true},
+		{" info ", 6, true},
+		{"trace", 7, true},
+		{"5", 5, true},
+		{json.Number("30"), 6, true},
+		{json.Number("60"), 2, true},
+		{json.Number("8"), 0, false},
+		{"verbose", 0, false},
+		{true, 0, false},
+	}
+	for _, test := range tests {
+		level, ok := ParseLevel(test.value)
+		if level != test.level || ok != test.ok {
+			t.Errorf("%#v: expected %d %t, got %d %t", test.value, test.level, test.ok, level, ok)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2018, 3, 14, 15, 9, 26, 535000000, time.UTC)
+	tests := []interface{}{
+		"2018-03-14T15:09:26.535Z",
+		"2018-03-14T16:09:26.535+01:00",
+		"2018-03-14 15:09:26.535",
+		json.Number("1521040166.535"),
+		json.Number("1521040166535"),
+		json.Number("1521040166535000"),
+		json.Number("1521040166535000000"),
+		"1521040166.535",
+	}
+	for _, value := range tests {
+		ts, ok := ParseTimestamp(value)
+		if !ok || ts.Sub(expected).Round(time.Millisecond) != 0 {
+			t.Errorf("%#v: expected %s, got %s %t", value, expected, ts, ok)
+		}
+	}
+
+	for _, value := range []interface{}{"yesterday", json.Number("-1"), "", nil} {
+		if ts, ok := ParseTimestamp(value); ok {
+			t.Errorf("%#v is not a timestamp, got %s", value, ts)
+		}
+	}
+}
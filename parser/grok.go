@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+// grokPatterns is the library of built-in patterns, in the spirit of those of
+// Logstash, written for the RE2 syntax of the regexp package.
+var grokPatterns = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `[+-]?\d+`,
+	"BASE10NUM":    `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"NUMBER":       `%{BASE10NUM}`,
+	"BASE16NUM":    `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":       `\b[1-9]\d*\b`,
+	"NONNEGINT":    `\b\d+\b`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":          `(?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}`,
+
+	"IPV4":     `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	"IPV6":     `(?:[A-Fa-f0-9]{0,4}:){2,7}(?:%{IPV4}|[A-Fa-f0-9]{1,4})?`,
+	"IP":       `%{IPV6}|%{IPV4}`,
+	"HOSTNAME": `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST": `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"UNIXPATH":     `(?:/[^/\s]*)+`,
+	"PATH":         `%{UNIXPATH}`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+.-]*`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]une?|[Jj]uly?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12]\d|3[01]|[1-9]`,
+	"DAY":               `\b(?:Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?)\b`,
+	"YEAR":              `\d\d(?:\d\d)?`,
+	"HOUR":              `2[0-3]|[01]?\d`,
+	"MINUTE":            `[0-5]\d`,
+	"SECOND":            `(?:[0-5]?\d|60)(?:[.,]\d+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}:?%{MINUTE}`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} [+-]\d{4}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	"LOGLEVEL": `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+
+	// The access logs of Apache, and of nginx with its default combined
+	// format.
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response:int} (?:%{NUMBER:bytes:int}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+// maxGrokDepth is the maximum depth of the references between patterns,
+// beyond which they are considered recursive.
+const maxGrokDepth = 20
+
+// grokReference matches the references to a pattern: %{NAME}, %{NAME:field}
+// or %{NAME:field:type}, the type being int or float.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\-]+))?(?::(int|float))?\}`)
+
+// grokGroup prefixes the names of the groups capturing the fields referenced
+// by %{NAME:field}, as the field names may not be valid group names.
+const grokGroup = "__grok"
+
+// capture is a field captured by a pattern.
+type capture struct {
+	// group is the index of the capturing group.
+	group int
+	field string
+	// kind is the type the value is converted to, int, float or a string
+	// if empty.
+	kind string
+}
+
+// grokRule is a compiled grok rule.
+type grokRule struct {
+	name     string
+	selector selector
+	regexp   *regexp.Regexp
+	captures []capture
+}
+
+// Grok extracts fields from the messages with named captures, in the way of
+// the Logstash grok filter.
+type Grok struct {
+	rules []*grokRule
+}
+
+// NewGrok compiles the grok rules. The patterns given take precedence over
+// the built-in ones.
+func NewGrok(c config.Grok) (*Grok, error) {
+	patterns := map[string]string{}
+	for name, pattern := range grokPatterns {
+		patterns[name] = pattern
+	}
+	for name, pattern := range c.Patterns {
+		patterns[name] = pattern
+	}
+
+	g := &Grok{}
+	for _, r := range c.Rules {
+		rule, err := compileGrok(r, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("grok rule %q: %s", r.Name, err)
+		}
+		g.rules = append(g.rules, rule)
+	}
+	return g, nil
+}
+
+func compileGrok(r config.GrokRule, patterns map[string]string) (*grokRule, error) {
+	var captures []capture
+	var expand func(pattern string, depth int) (string, error)
+	expand = func(pattern string, depth int) (string, error) {
+		if depth > maxGrokDepth {
+			return "", fmt.Errorf("the patterns are recursive")
+		}
+		var err error
+		expanded := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+			if err != nil {
+				return ""
+			}
+			m := grokReference.FindStringSubmatch(reference)
+			definition, ok := patterns[m[1]]
+			if !ok {
+				err = fmt.Errorf("unknown pattern %q", m[1])
+				return ""
+			}
+			var group string
+			if m[2] != "" {
+				group = fmt.Sprintf("(?P<%s%d>", grokGroup, len(captures))
+				captures = append(captures, capture{field: m[2], kind: m[3]})
+			}
+			definition, err = expand(definition, depth+1)
+			if group == "" {
+				return "(?:" + definition + ")"
+			}
+			return group + definition + ")"
+		})
+		return expanded, err
+	}
+
+	expanded, err := expand(r.Pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &grokRule{
+		name:     r.Name,
+		selector: newSelector(r.Units, r.Identifiers),
+		regexp:   re,
+	}
+	for i, name := range re.SubexpNames() {
+		switch {
+		case strings.HasPrefix(name, grokGroup):
+			n, _ := strconv.Atoi(name[len(grokGroup):])
+			c := captures[n]
+			c.group = i
+			rule.captures = append(rule.captures, c)
+		case name != "":
+			// The named groups of the pattern itself are captured too.
+			rule.captures = append(rule.captures, capture{group: i, field: name})
+		}
+	}
+	return rule, nil
+}
+
+// Enrich matches the message of the record against the rules selecting it,
+// in order, and adds the fields captured by the first that matches.
+func (g *Grok) Enrich(r *journald.Record) {
+	for _, rule := range g.rules {
+		if !rule.selector.matches(&r.Entry) {
+			continue
+		}
+		m := rule.regexp.FindStringSubmatchIndex(r.Entry.Message)
+		if m == nil {
+			metrics.GrokMisses.Inc(rule.name)
+			continue
+		}
+		metrics.GrokMatches.Inc(rule.name)
+		for _, c := range rule.captures {
+			start, end := m[2*c.group], m[2*c.group+1]
+			if start < 0 {
+				// The group did not participate in the match.
+				continue
+			}
+			r.SetField(c.field, c.convert(r.Entry.Message[start:end]))
+		}
+		return
+	}
+}
+
+// convert returns the captured value converted to the type of the capture,
+// or unchanged if it cannot be.
+func (c capture) convert(value string) interface{} {
+	switch c.kind {
+	case "int":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+func TestGrokBuiltinPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		miss    []string
+	}{
+		{"IP", []string{"10.0.0.1", "2001:db8::1", "::1", "::ffff:10.0.0.1"}, []string{"10.0.0.256", "host"}},
+		{"NUMBER", []string{"42", "-1.5", ".5"}, []string{"abc"}},
+		{"HTTPDATE", []string{"14/Mar/2018:15:09:26 +0100"}, []string{"2018-03-14"}},
+		{"TIMESTAMP_ISO8601", []string{"2018-03-14T15:09:26.535Z", "2018-03-14 15:09:26+01:00"}, []string{"14/Mar/2018"}},
+		{"SYSLOGTIMESTAMP", []string{"Mar  4 15:09:26"}, []string{"4 Mar 15:09"}},
+		{"UUID", []string{"3d8c1b2e-5f6a-11e8-9c2d-fa7ae01bbebc"}, []string{"3d8c1b2e"}},
+		{"URI", []string{"https://user@example.org:8443/a/b?c=d"}, []string{"example.org"}},
+		{"LOGLEVEL", []string{"WARNING", "error"}, []string{"verbose"}},
+	}
+	for _, test := range tests {
+		g, err := NewGrok(config.Grok{Rules: []config.GrokRule{{Name: test.pattern, Pattern: "^%{" + test.pattern + "}$"}}})
+		if err != nil {
+			t.Fatalf("%s: %s", test.pattern, err)
+		}
+		for _, s := range test.match {
+			if !g.rules[0].regexp.MatchString(s) {
+				t.Errorf("%s should match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.miss {
+			if g.rules[0].regexp.MatchString(s) {
+				t.Errorf("%s should not match %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestGrok(t *testing.T) {
+	g, err := NewGrok(config.Grok{
+		Patterns: map[string]string{"SSHRESULT": "Accepted|Failed"},
+		Rules: []config.GrokRule{
+			{Name: "nginx", Units: []string{"nginx"}, Pattern: "^%{COMBINEDAPACHELOG}"},
+			{Name: "sshd-auth", Identifiers: []string{"sshd"},
+				Pattern: `^%{SSHRESULT:ssh.result} %{WORD:ssh.method} for (?:invalid user )?%{USERNAME:ssh.user} from %{IP:ssh.client} port %{POSINT:ssh.port:int}`},
+			{Name: "sshd-session", Identifiers: []string{"sshd"}, Pattern: `session (?P<session_state>opened|closed) for user %{USERNAME:ssh.user}`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		SystemdUnit: "nginx.service",
+		Message:     `10.0.0.1 - - [14/Mar/2018:15:09:26 +0100] "GET /index.html HTTP/1.1" 200 612 "-" "curl/7.58.0"`,
+	}}
+	g.Enrich(r)
+	expected := map[string]interface{}{
+		"clientip": "10.0.0.1", "ident": "-", "auth": "-", "timestamp": "14/Mar/2018:15:09:26 +0100",
+		"verb": "GET", "request": "/index.html", "httpversion": "1.1", "response": int64(200), "bytes": int64(612),
+		"referrer": `"-"`, "agent": `"curl/7.58.0"`,
+	}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		SyslogIdentifier: "sshd",
+		Message:          "pam_unix(sshd:session): session opened for user root by (uid=0)",
+	}}
+	g.Enrich(r)
+	expected = map[string]interface{}{"session_state": "opened", "ssh.user": "root"}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		SyslogIdentifier: "sshd",
+		Message:          "Failed password for invalid user admin from 2001:db8::1 port 4242 ssh2",
+	}}
+	g.Enrich(r)
+	expected = map[string]interface{}{"ssh.result": "Failed", "ssh.method": "password", "ssh.user": "admin", "ssh.client": "2001:db8::1", "ssh.port": int64(4242)}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{SyslogIdentifier: "cron", Message: "Failed password for root from 10.0.0.1 port 22"}}
+	g.Enrich(r)
+	if r.Fields != nil {
+		t.Errorf("the entries of the other identifiers should not be matched, got %v", r.Fields)
+	}
+}
+
+func TestGrokInvalid(t *testing.T) {
+	for _, c := range []config.Grok{
+		{Rules: []config.GrokRule{{Name: "unknown", Pattern: "%{NOPE}"}}},
+		{Rules: []config.GrokRule{{Name: "invalid", Pattern: "(unbalanced"}}},
+		{Patterns: map[string]string{"A": "%{B}", "B": "%{A}"}, Rules: []config.GrokRule{{Name: "recursive", Pattern: "%{A}"}}},
+	} {
+		if _, err := NewGrok(c); err == nil {
+			t.Errorf("%+v should not compile", c)
+		}
+	}
+}
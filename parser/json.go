@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// JSON turns the messages that are JSON objects, as logged by many services
+// to their standard output, into GELF additional fields.
+type JSON struct {
+	prefix   string
+	maxDepth int
+	lifter   lifter
+}
+
+// NewJSON returns a JSON message parser.
+func NewJSON(c config.JSON) *JSON {
+	return &JSON{
+		prefix:   c.Prefix,
+		maxDepth: c.MaxDepth,
+		lifter:   lifter{c.MessageKeys, c.LevelKeys, c.TimestampKeys},
+	}
+}
+
+// Enrich parses the message of the record if it is a JSON object. Its
+// message, level and timestamp are lifted from the first of the well-known
+// keys found, and its other keys are flattened into fields, the nested
+// objects being joined with underscores. The messages that are not valid
+// JSON objects are left untouched.
+func (p *JSON) Enrich(r *journald.Record) {
+	message := strings.TrimSpace(r.Entry.Message)
+	if !strings.HasPrefix(message, "{") || !strings.HasSuffix(message, "}") {
+		return
+	}
+	decoder := json.NewDecoder(strings.NewReader(message))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || decoder.More() {
+		return
+	}
+
+	p.lifter.lift(r, object)
+	p.flatten(r, p.prefix, object, 1)
+}
+
+// flatten adds the values of the object as fields. The objects nested deeper
+// than the maximum depth, and the arrays, are added as JSON text.
+func (p *JSON) flatten(r *journald.Record, prefix string, object map[string]interface{}, depth int) {
+	for key, value := range object {
+		name := prefix + key
+		switch v := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			if depth < p.maxDepth {
+				p.flatten(r, name+"_", v, depth+1)
+			} else {
+				r.SetField(name, encode(v))
+			}
+		case []interface{}:
+			r.SetField(name, encode(v))
+		case bool:
+			// GELF only allows strings and numbers.
+			r.SetField(name, strconv.FormatBool(v))
+		default:
+			r.SetField(name, v)
+		}
+	}
+}
+
+func encode(value interface{}) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+func TestJSON(t *testing.T) {
+	c := config.Default().JSON
+	c.MaxDepth = 2
+	p := NewJSON(c)
+
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		Priority: "6",
+		Message:  `{"msg":"request served","level":"error","ts":"2018-03-14T15:09:26Z","status":500,"ok":false,"user":null,"http":{"method":"GET","headers":{"accept":"*/*"}},"tags":["a","b"]}`,
+	}}
+	p.Enrich(r)
+
+	if r.Entry.Message != "request served" || r.Entry.Priority != "3" {
+		t.Errorf("the message and level should be lifted, got %q %q", r.Entry.Message, r.Entry.Priority)
+	}
+	if !r.Timestamp.Equal(time.Date(2018, 3, 14, 15, 9, 26, 0, time.UTC)) {
+		t.Errorf("the timestamp should be lifted, got %s", r.Timestamp)
+	}
+	expected := map[string]interface{}{
+		"status":       json.Number("500"),
+		"ok":           "false",
+		"http_method":  "GET",
+		"http_headers": `{"accept":"*/*"}`,
+		"tags":         `["a","b"]`,
+	}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+}
+
+func TestJSONPrefix(t *testing.T) {
+	c := config.Default().JSON
+	c.Prefix = "app_"
+	p := NewJSON(c)
+
+	// The keys that cannot be lifted are kept as fields.
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		Priority: "6",
+		Message:  `{"message":42,"level":"chatty","time":"later"}`,
+	}}
+	p.Enrich(r)
+	if r.Entry.Message != `{"message":42,"level":"chatty","time":"later"}` || r.Entry.Priority != "6" || !r.Timestamp.IsZero() {
+		t.Errorf("nothing should be lifted, got %+v", r)
+	}
+	expected := map[string]interface{}{"app_message": json.Number("42"), "app_level": "chatty", "app_time": "later"}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+}
+
+func TestJSONInvalid(t *testing.T) {
+	p := NewJSON(config.Default().JSON)
+	for _, message := range []string{
+		"plain text",
+		`{"unterminated":`,
+		`{"a":1} {"b":2}`,
+		`["not", "an", "object"]`,
+		`{not json}`,
+	} {
+		r := &journald.Record{Entry: journald.JournaldJSONLogEntry{Message: message}}
+		p.Enrich(r)
+		if r.Entry.Message != message || r.Fields != nil {
+			t.Errorf("%q should be left untouched, got %+v", message, r)
+		}
+	}
+}
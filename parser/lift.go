@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"strconv"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// lifter lifts the message, the level and the timestamp of an entry from
+// the fields parsed from its message.
+type lifter struct {
+	messageKeys   []string
+	levelKeys     []string
+	timestampKeys []string
+}
+
+// lift sets the message, the level and the timestamp of the record from the
+// first of their keys present in the fields, and removes the keys lifted
+// from the fields. The values that cannot be understood are left in the
+// fields. It returns true if the message was lifted.
+func (l lifter) lift(r *journald.Record, fields map[string]interface{}) bool {
+	lifted := false
+	if key, value := lookup(fields, l.messageKeys); key != "" {
+		if text, ok := value.(string); ok && text != "" {
+			r.Entry.Message = text
+			delete(fields, key)
+			lifted = true
+		}
+	}
+	if key, value := lookup(fields, l.levelKeys); key != "" {
+		if level, ok := ParseLevel(value); ok {
+			r.Entry.Priority = strconv.Itoa(level)
+			delete(fields, key)
+		}
+	}
+	if key, value := lookup(fields, l.timestampKeys); key != "" {
+		if t, ok := ParseTimestamp(value); ok {
+			r.Timestamp = t
+			delete(fields, key)
+		}
+	}
+	return lifted
+}
+
+// lookup returns the first of the keys present in the fields, along with
+// its value.
+func lookup(fields map[string]interface{}, keys []string) (string, interface{}) {
+	for _, key := range keys {
+		if value, ok := fields[key]; ok {
+			return key, value
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// Logfmt turns the key=value pairs of the messages, as logged by the text
+// encoders of logrus and zap and by many daemons, into GELF additional
+// fields.
+type Logfmt struct {
+	selector selector
+	prefix   string
+	strip    bool
+	lifter   lifter
+}
+
+// NewLogfmt returns a logfmt message parser.
+func NewLogfmt(c config.Logfmt) *Logfmt {
+	return &Logfmt{
+		selector: newSelector(c.Units, c.Identifiers),
+		prefix:   c.Prefix,
+		strip:    c.Strip,
+		lifter:   lifter{c.MessageKeys, c.LevelKeys, c.TimestampKeys},
+	}
+}
+
+// pair is a key=value pair found in a message.
+type pair struct {
+	key   string
+	value interface{}
+	// start and end delimit the pair in the message.
+	start, end int
+}
+
+// key matches the keys of the pairs, the words with other characters before
+// an equal sign are text.
+var logfmtKey = regexp.MustCompile(`^[\pL_][\pL\pN_.\-/]*$`)
+
+// number matches the values sent as numbers, as written in JSON, so that
+// values such as 0123 are kept as text.
+var number = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d+)?$`)
+
+// Enrich parses the key=value pairs of the message of the record, if it was
+// logged by one of the selected units or identifiers. Its message, level and
+// timestamp are lifted from the first of the well-known keys found, and its
+// other pairs are added as fields, the unquoted numbers as numbers. When no
+// message is lifted and stripping is enabled, the pairs are removed from the
+// message, unless nothing would be left of it.
+func (p *Logfmt) Enrich(r *journald.Record) {
+	if !p.selector.matches(&r.Entry) {
+		return
+	}
+	message := r.Entry.Message
+	pairs := parseLogfmt(message)
+	if len(pairs) == 0 {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(pairs))
+	for _, pair := range pairs {
+		fields[pair.key] = pair.value
+	}
+	if !p.lifter.lift(r, fields) && p.strip {
+		var text []string
+		last := 0
+		for _, pair := range pairs {
+			text = append(text, message[last:pair.start])
+			last = pair.end
+		}
+		text = append(text, message[last:])
+		if stripped := strings.Join(strings.Fields(strings.Join(text, " ")), " "); stripped != "" {
+			r.Entry.Message = stripped
+		}
+	}
+	for key, value := range fields {
+		r.SetField(p.prefix+key, value)
+	}
+}
+
+// parseLogfmt returns the key=value pairs of a message. The values may be
+// quoted, with Go escape sequences, and a key without a value is not a pair.
+func parseLogfmt(message string) []pair {
+	var pairs []pair
+	i := 0
+	for i < len(message) {
+		for i < len(message) && message[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(message) && message[i] != ' ' && message[i] != '=' {
+			i++
+		}
+		key := message[start:i]
+		if i >= len(message) || message[i] != '=' || !logfmtKey.MatchString(key) {
+			// Not a pair, skip the rest of the word.
+			for i < len(message) && message[i] != ' ' {
+				i++
+			}
+			continue
+		}
+		i++
+
+		if i < len(message) && message[i] == '"' {
+			end := quotedEnd(message, i)
+			if end < 0 {
+				// An unterminated quote, the rest of the message is text.
+				break
+			}
+			value, err := strconv.Unquote(message[i:end])
+			if err != nil {
+				value = message[i+1 : end-1]
+			}
+			pairs = append(pairs, pair{key: key, value: value, start: start, end: end})
+			i = end
+			continue
+		}
+
+		valueStart := i
+		for i < len(message) && message[i] != ' ' {
+			i++
+		}
+		var value interface{} = message[valueStart:i]
+		if number.MatchString(message[valueStart:i]) {
+			value = json.Number(message[valueStart:i])
+		}
+		pairs = append(pairs, pair{key: key, value: value, start: start, end: i})
+	}
+	return pairs
+}
+
+// quotedEnd returns the index following the closing quote of the quoted
+// string starting at start, or -1 if it is not terminated.
+func quotedEnd(message string, start int) int {
+	for i := start + 1; i < len(message); i++ {
+		switch message[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
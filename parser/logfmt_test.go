@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	pairs := parseLogfmt(`GET /index.html status=200 took=0.5 zip=0123 user="jane \"jd\" doe" ok=true  empty= =skipped a=b=c url=http://x/?q=1 trailing="unterminated`)
+	expected := []pair{
+		{"status", json.Number("200"), 16, 26},
+		{"took", json.Number("0.5"), 27, 35},
+		{"zip", "0123", 36, 44},
+		{"user", `jane "jd" doe`, 45, 67},
+		{"ok", "true", 68, 75},
+		{"empty", "", 77, 83},
+		{"a", "b=c", 93, 98},
+		{"url", "http://x/?q=1", 99, 116},
+	}
+	if fmt.Sprint(pairs) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, pairs)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	p := NewLogfmt(config.Default().Logfmt)
+
+	// A logrus entry, written by its text formatter.
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		Priority: "6",
+		Message:  `time="2018-03-14T15:09:26Z" level=warning msg="disk almost full" free=5 mount=/var`,
+	}}
+	p.Enrich(r)
+	if r.Entry.Message != "disk almost full" || r.Entry.Priority != "4" {
+		t.Errorf("the message and level should be lifted, got %q %q", r.Entry.Message, r.Entry.Priority)
+	}
+	if !r.Timestamp.Equal(time.Date(2018, 3, 14, 15, 9, 26, 0, time.UTC)) {
+		t.Errorf("the timestamp should be lifted, got %s", r.Timestamp)
+	}
+	expected := map[string]interface{}{"free": json.Number("5"), "mount": "/var"}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{Message: "no pairs here"}}
+	p.Enrich(r)
+	if r.Entry.Message != "no pairs here" || r.Fields != nil {
+		t.Errorf("a message without pairs should be left untouched, got %+v", r)
+	}
+}
+
+func TestLogfmtStrip(t *testing.T) {
+	c := config.Default().Logfmt
+	c.Strip = true
+	c.Prefix = "kv_"
+	c.Units = []string{"sshd"}
+	c.Identifiers = []string{"haproxy"}
+	p := NewLogfmt(c)
+
+	r := &journald.Record{Entry: journald.JournaldJSONLogEntry{
+		SystemdUnit: "sshd.service",
+		Message:     `Accepted publickey user=root  from=10.0.0.1 port=22 for session`,
+	}}
+	p.Enrich(r)
+	if r.Entry.Message != "Accepted publickey for session" {
+		t.Errorf("the pairs should be stripped, got %q", r.Entry.Message)
+	}
+	expected := map[string]interface{}{"kv_user": "root", "kv_from": "10.0.0.1", "kv_port": json.Number("22")}
+	if fmt.Sprint(r.Fields) != fmt.Sprint(expected) {
+		t.Errorf("unexpected fields %v", r.Fields)
+	}
+
+	// Nothing would be left of the message.
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{SyslogIdentifier: "haproxy", Message: "a=1 b=2"}}
+	p.Enrich(r)
+	if r.Entry.Message != "a=1 b=2" || len(r.Fields) != 2 {
+		t.Errorf("the message should be kept, got %+v", r)
+	}
+
+	r = &journald.Record{Entry: journald.JournaldJSONLogEntry{SystemdUnit: "cron.service", Message: "a=1"}}
+	p.Enrich(r)
+	if r.Fields != nil {
+		t.Errorf("the entries of the other units should not be parsed, got %v", r.Fields)
+	}
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/cdemers/journald2graylog/journald"
+)
+
+// selector selects the entries logged by some systemd units or syslog
+// identifiers.
+type selector struct {
+	units       map[string]bool
+	identifiers map[string]bool
+}
+
+// newSelector returns a selector of the entries logged by the given units,
+// with or without their .service suffix, or the given identifiers. It
+// selects all the entries if none are given.
+func newSelector(units, identifiers []string) selector {
+	s := selector{}
+	if len(units) > 0 || len(identifiers) > 0 {
+		s.units = map[string]bool{}
+		s.identifiers = map[string]bool{}
+	}
+	for _, unit := range units {
+		s.units[strings.TrimSuffix(unit, ".service")] = true
+	}
+	for _, identifier := range identifiers {
+		s.identifiers[identifier] = true
+	}
+	return s
+}
+
+func (s selector) matches(e *journald.JournaldJSONLogEntry) bool {
+	if s.units == nil {
+		return true
+	}
+	return (e.SystemdUnit != "" && s.units[strings.TrimSuffix(e.SystemdUnit, ".service")]) ||
+		(e.SyslogIdentifier != "" && s.identifiers[e.SyslogIdentifier])
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cdemers/journald2graylog/blacklist"
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/gelf"
+	"github.com/cdemers/journald2graylog/host"
+	"github.com/cdemers/journald2graylog/journald"
+	"github.com/cdemers/journald2graylog/logging"
+	"github.com/cdemers/journald2graylog/metrics"
+	"github.com/cdemers/journald2graylog/output"
+)
+
+// stage is implemented by the steps of the processing that may hold records
+// back until they are complete, such as the joining of multiline messages.
+type stage interface {
+	// Add gives a new record to the stage, and returns the records that are
+	// complete because of it.
+	Add(r *journald.Record, now time.Time) []*journald.Record
+	// Flush returns the records that waited long enough to be sent.
+	Flush(now time.Time) []*journald.Record
+	// FlushAll returns all the records held back.
+	FlushAll() []*journald.Record
+}
+
+// enricher is implemented by the steps of the processing that add to, or
+// transform, every record.
+type enricher interface {
+	Enrich(r *journald.Record)
+}
+
+// processor turns the journald JSON log lines into GELF messages, and queues
+// them to be sent.
+type processor struct {
+	blacklist blacklist.Blacklist
+	stages    []stage
+	enrichers []enricher
+	queue     chan<- *output.Message
+
+	enableRawLogLine bool
+	hosts            *host.Resolver
+	// originalHost, if set, is the field the _HOSTNAME of the entries is
+	// kept in when it is not their host.
+	originalHost   string
+	maxMessageSize int
+	debugPayloads  bool
+	facility       *template.Template
+	fieldTypes     map[string]string
+	// placeholder is the short message of the entries whose message is
+	// empty, they are dropped if it is empty.
+	placeholder      string
+	timestampSources []string
+	maxFuture        time.Duration
+}
+
+// processLine filters and parses a single log line.
+func (p *processor) processLine(line []byte, now time.Time) {
+	if p.blacklist.IsBlacklisted(line) {
+		metrics.Blacklisted.Inc()
+		return
+	}
+
+	record := parseRecord(line, p.maxMessageSize)
+	if record == nil {
+		metrics.ParseFailures.Inc()
+		return
+	}
+
+	p.forward([]*journald.Record{record}, 0, now)
+}
+
+// forward gives the records to the stages, starting from the given one, and
+// sends those that come out of the last stage.
+func (p *processor) forward(records []*journald.Record, from int, now time.Time) {
+	for _, s := range p.stages[from:] {
+		var complete []*journald.Record
+		for _, r := range records {
+			complete = append(complete, s.Add(r, now)...)
+		}
+		records = complete
+	}
+	for _, r := range records {
+		p.send(r, now)
+	}
+}
+
+// flush sends the records that waited long enough in the stages.
+func (p *processor) flush(now time.Time) {
+	for i, s := range p.stages {
+		p.forward(s.Flush(now), i+1, now)
+	}
+}
+
+// flushAll sends all the records still held back by the stages.
+func (p *processor) flushAll() {
+	now := time.Now()
+	for i, s := range p.stages {
+		p.forward(s.FlushAll(), i+1, now)
+	}
+}
+
+// send enriches the record, builds its GELF payload and queues it.
+func (p *processor) send(record *journald.Record, now time.Time) {
+	for _, e := range p.enrichers {
+		e.Enrich(record)
+	}
+	gelf.ConvertFields(record.Fields, p.fieldTypes)
+
+	// The entries without a valid timestamp are dated with the time they
+	// are sent at.
+	timestamp, ok := record.Time(p.timestampSources, now, p.maxFuture)
+	if !ok {
+		metrics.InvalidTimestamps.Inc()
+		timestamp = now
+	}
+
+	// GELF requires a short message.
+	if strings.TrimSpace(record.Entry.Message) == "" {
+		if p.placeholder == "" {
+			metrics.EmptyMessages.Inc(config.EmptyDrop)
+			return
+		}
+		metrics.EmptyMessages.Inc(config.EmptyPlaceholder)
+		record.Entry.Message = p.placeholder
+	}
+
+	host := p.hosts.Host(&record.Entry)
+	if p.originalHost != "" && record.Entry.Hostname != "" && record.Entry.Hostname != host {
+		record.SetField(p.originalHost, record.Entry.Hostname)
+	}
+
+	gelfPayload, err := prepareGelfPayload(&p.enableRawLogLine, record, host, timestamp, formatFacility(p.facility, record))
+	if err != nil {
+		metrics.InvalidEntries.Inc()
+		logging.WithFields(logging.Fields{"error": err}).Warnf("Could not build the GELF payload of an entry, it will be skipped.")
+		return
+	}
+
+	if p.debugPayloads {
+		logging.WithFields(logging.Fields{"payload": gelfPayload}).Debugf("GELF payload")
+	}
+
+	p.queue <- &output.Message{
+		Line:    record.Line,
+		Payload: []byte(gelfPayload),
+		Record:  record,
+		Host:    host,
+		Time:    timestamp,
+	}
+}
@@ -0,0 +1,68 @@
+package redact
+
+import "regexp"
+
+// Names of the built-in detectors.
+const (
+	CreditCard  = "credit_card"
+	Email       = "email"
+	BearerToken = "bearer_token"
+	Password    = "password"
+)
+
+// secretGroup is the name of the group a detector uses to redact only a part
+// of its matches, such as the value of a password=value pair.
+const secretGroup = "secret"
+
+// detector finds a kind of sensitive data in a text.
+type detector struct {
+	name   string
+	regexp *regexp.Regexp
+	// valid, if set, tells whether a match really is sensitive.
+	valid func(match string) bool
+}
+
+// builtins are the built-in detectors, by name.
+var builtins = map[string]*detector{
+	CreditCard: {
+		name:   CreditCard,
+		regexp: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid:  luhn,
+	},
+	Email: {
+		name:   Email,
+		regexp: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	},
+	BearerToken: {
+		name:   BearerToken,
+		regexp: regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`),
+	},
+	Password: {
+		name: Password,
+		// The values of the password-like keys, in key=value, key: value and
+		// JSON pairs, and the passwords of the URLs.
+		regexp: regexp.MustCompile(`(?i)(?:passw(?:or)?d|pwd|secret|api[_-]?key|access[_-]?token)["']?\s*[:=]\s*["']?(?P<secret>[^\s"'&,;\\]+)|://[^:/\s@]+:(?P<secret>[^/\s@]+)@`),
+	},
+}
+
+// luhn returns true if the digits of the number, ignoring the spaces and
+// dashes, pass the Luhn checksum used by the payment card numbers.
+func luhn(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
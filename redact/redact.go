@@ -0,0 +1,220 @@
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/cdemers/journald2graylog/config"
+	"github.com/cdemers/journald2graylog/journald"
+	"github.com/cdemers/journald2graylog/metrics"
+)
+
+// hashSize is the number of bytes of the HMAC kept in the replacements.
+const hashSize = 16
+
+// ignoredFields are the journal fields that are not redacted, as they hold
+// identifiers and numbers that could be mistaken for sensitive data, the
+// fields whose name starts with a double underscore are not redacted either.
+var ignoredFields = map[string]bool{
+	"PRIORITY":                    true,
+	"SYSLOG_FACILITY":             true,
+	"SYSLOG_PID":                  true,
+	"ERRNO":                       true,
+	"CODE_LINE":                   true,
+	"_PID":                        true,
+	"_UID":                        true,
+	"_GID":                        true,
+	"_BOOT_ID":                    true,
+	"_MACHINE_ID":                 true,
+	"_AUDIT_SESSION":              true,
+	"_AUDIT_LOGINUID":             true,
+	"_SYSTEMD_OWNER_UID":          true,
+	"_CAP_EFFECTIVE":              true,
+	"_SOURCE_REALTIME_TIMESTAMP":  true,
+	"_SOURCE_MONOTONIC_TIMESTAMP": true,
+	"CONTAINER_ID":                true,
+	"CONTAINER_ID_FULL":           true,
+}
+
+// Redactor replaces the sensitive data found in the entries, such as credit
+// card numbers or passwords, or their HMAC, before they are sent.
+type Redactor struct {
+	detectors   []*detector
+	replacement string
+	key         []byte
+	rawLine     bool
+}
+
+// New returns a redactor using the built-in detectors and the patterns of
+// the configuration. The raw log line is only redacted if rawLine is true,
+// as it is not sent otherwise.
+func New(c config.Redact, rawLine bool) (*Redactor, error) {
+	r := &Redactor{
+		replacement: c.Replacement,
+		rawLine:     rawLine,
+	}
+	for _, name := range c.Detectors {
+		d, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		r.detectors = append(r.detectors, d)
+	}
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %s", p.Name, err)
+		}
+		r.detectors = append(r.detectors, &detector{name: p.Name, regexp: re})
+	}
+	if c.Mode == config.RedactHash {
+		key, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the redaction key: %s", err)
+		}
+		key = bytes.TrimSpace(key)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("the redaction key file %s is empty", c.KeyFile)
+		}
+		r.key = key
+	}
+	return r, nil
+}
+
+// Enrich redacts every journal field of the entry that is forwarded, but the
+// ignored ones, along with the full message, the additional fields and the
+// raw log line of the record.
+func (r *Redactor) Enrich(record *journald.Record) {
+	entry := reflect.ValueOf(&record.Entry).Elem()
+	for i := 0; i < entry.NumField(); i++ {
+		name := strings.Split(entry.Type().Field(i).Tag.Get("json"), ",")[0]
+		field := entry.Field(i)
+		if ignored(name) || field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+		field.SetString(r.Redact(field.String()))
+	}
+	record.FullMessage = r.Redact(record.FullMessage)
+	for name, value := range record.Fields {
+		if text, ok := value.(string); ok {
+			record.Fields[name] = r.Redact(text)
+		}
+	}
+	if r.rawLine {
+		record.Line = r.redactLine(record.Line)
+	}
+}
+
+// ignored returns true if the journal field is not redacted.
+func ignored(name string) bool {
+	return strings.HasPrefix(name, "__") || ignoredFields[name]
+}
+
+// Redact returns the text with the sensitive data found in it replaced.
+func (r *Redactor) Redact(text string) string {
+	return r.redact(text, true)
+}
+
+// redact replaces the sensitive data found in the text, and counts the
+// redactions if count is true.
+func (r *Redactor) redact(text string, count bool) string {
+	for _, d := range r.detectors {
+		text = r.apply(d, text, count)
+	}
+	return text
+}
+
+// apply replaces the matches of a detector in the text, or only their
+// secret group if the detector has one.
+func (r *Redactor) apply(d *detector, text string, count bool) string {
+	matches := d.regexp.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+	names := d.regexp.SubexpNames()
+	var redacted bytes.Buffer
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		for i, name := range names {
+			if name == secretGroup && m[2*i] >= 0 {
+				start, end = m[2*i], m[2*i+1]
+				break
+			}
+		}
+		if d.valid != nil && !d.valid(text[m[0]:m[1]]) {
+			continue
+		}
+		redacted.WriteString(text[last:start])
+		redacted.WriteString(r.replace(text[start:end]))
+		last = end
+		if count {
+			metrics.Redactions.Inc(d.name)
+		}
+	}
+	redacted.WriteString(text[last:])
+	return redacted.String()
+}
+
+// replace returns the replacement of a sensitive value, the HMAC of the
+// value if a key is set, so that the same values can still be correlated.
+func (r *Redactor) replace(value string) string {
+	if r.key == nil {
+		return r.replacement
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "[HMAC:" + hex.EncodeToString(mac.Sum(nil)[:hashSize]) + "]"
+}
+
+// redactLine redacts the string values of a raw journald JSON log line,
+// or of the lines joined into a record. The line is returned unchanged if
+// nothing was redacted, and redacted as text if it cannot be parsed.
+func (r *Redactor) redactLine(line []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var entries []map[string]interface{}
+	for decoder.More() {
+		var fields map[string]interface{}
+		if err := decoder.Decode(&fields); err != nil {
+			return []byte(r.redact(string(line), false))
+		}
+		entries = append(entries, fields)
+	}
+
+	changed := false
+	for _, fields := range entries {
+		for name, value := range fields {
+			text, ok := value.(string)
+			if !ok || ignored(name) {
+				continue
+			}
+			// The redactions of the raw line were already counted in the
+			// message and the fields.
+			if redacted := r.redact(text, false); redacted != text {
+				fields[name] = redacted
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return line
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	for _, fields := range entries {
+		if err := encoder.Encode(fields); err != nil {
+			return []byte(r.redact(string(line), false))
+		}
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+}